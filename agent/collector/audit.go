@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAuditPending caps the events an AuditAssembler holds; beyond it the
+// oldest is emitted incomplete.
+const maxAuditPending = 256
+
+// msg=audit(1700000000.123:4567): timestamp and serial of the event.
+var auditIDRe = regexp.MustCompile(`msg=audit\((\d+\.\d+:\d+)\)`)
+
+// AuditAssembler joins the records of auditd events (SYSCALL, EXECVE,
+// PATH, CWD, ...), which auditd writes as separate lines sharing one
+// event id, into one message with a record per line. An event is complete
+// at its EOE record; events without one, such as USER_* events, are
+// emitted by Flush once no record was added for a while. Lines that are
+// no audit records are passed through.
+//
+// Events are emitted with the position before the first record of the
+// oldest event still pending, so a saved position never skips records of
+// an event that was not emitted yet.
+type AuditAssembler struct {
+	emit func(Line) bool
+
+	mu      sync.Mutex
+	pending map[string]*auditEvent
+	order   []string // ids of pending events, oldest first
+	pos     Position // after the last line added
+}
+
+type auditEvent struct {
+	records []string
+	updated time.Time
+	start   Position // before the first record
+}
+
+func NewAuditAssembler(emit func(Line) bool) *AuditAssembler {
+	return &AuditAssembler{emit: emit, pending: make(map[string]*auditEvent)}
+}
+
+// Add takes one line of audit.log from a Tailer. It returns false when an
+// event could not be delivered, like the emit of a Tailer.
+func (a *AuditAssembler) Add(line Line) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := a.pos
+	a.pos = line.Pos
+	m := auditIDRe.FindStringSubmatch(line.Text)
+	if line.Mark || m == nil {
+		if !a.emit(Line{Text: line.Text, Mark: line.Mark, Pos: a.safePos("")}) {
+			a.pos = start
+			return false
+		}
+		return true
+	}
+
+	id := m[1]
+	ev := a.pending[id]
+	if strings.HasPrefix(line.Text, "type=EOE ") {
+		if ev == nil {
+			return true
+		}
+		return a.emitEvent(id)
+	}
+	if ev == nil {
+		ev = &auditEvent{start: start}
+		a.pending[id] = ev
+		a.order = append(a.order, id)
+	}
+	ev.records = append(ev.records, line.Text)
+	ev.updated = time.Now()
+
+	for len(a.order) > maxAuditPending {
+		if !a.emitEvent(a.order[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush emits the pending events, oldest first, whose last record came
+// longer than age ago. It returns false when an event could not be
+// delivered.
+func (a *AuditAssembler) Flush(age time.Duration) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cutoff := time.Now().Add(-age)
+	for len(a.order) > 0 {
+		id := a.order[0]
+		if a.pending[id].updated.After(cutoff) {
+			break
+		}
+		if !a.emitEvent(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// emitEvent emits a pending event. An event that could not be delivered
+// stays pending.
+func (a *AuditAssembler) emitEvent(id string) bool {
+	if !a.emit(Line{Text: strings.Join(a.pending[id].records, "\n"), Pos: a.safePos(id)}) {
+		return false
+	}
+	delete(a.pending, id)
+	for i, pid := range a.order {
+		if pid == id {
+			a.order = slices.Delete(a.order, i, i+1)
+			break
+		}
+	}
+	return true
+}
+
+// safePos is the position up to which every record is emitted once the
+// event emitted ("" for none) is.
+func (a *AuditAssembler) safePos(emitted string) Position {
+	for _, id := range a.order {
+		if id != emitted {
+			return a.pending[id].start
+		}
+	}
+	return a.pos
+}
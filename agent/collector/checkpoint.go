@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint is the read position in one log file. Inode identifies the
+// file so that a rotation while the agent was down is noticed on restart.
+type Checkpoint struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// Checkpoints is the set of per-file read positions, persisted as JSON.
+type Checkpoints struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Checkpoint
+	dirty   bool
+}
+
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	c := &Checkpoints{path: path, entries: make(map[string]Checkpoint)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("decode checkpoints %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Checkpoints) Get(file string) (Checkpoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp, ok := c.entries[file]
+	return cp, ok
+}
+
+func (c *Checkpoints) Set(file string, cp Checkpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[file] != cp {
+		c.entries[file] = cp
+		c.dirty = true
+	}
+}
+
+// Save writes the checkpoints if anything changed since the last save. The
+// file is replaced atomically so a crash never leaves it half written.
+func (c *Checkpoints) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(c.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create checkpoint dir: %w", err)
+		}
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
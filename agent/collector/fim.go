@@ -0,0 +1,319 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFIMInterval = 5 * time.Minute
+	// fimSettle is how long a burst of change notifications may last
+	// before the files are rescanned.
+	fimSettle = 250 * time.Millisecond
+)
+
+type FIMConfig struct {
+	// Paths are files, directories (their files, not recursively) and
+	// filepath.Match globs such as "/home/*/.ssh/authorized_keys".
+	Paths []string
+	// Interval between full rescans; defaults to 5 minutes. On Linux,
+	// inotify triggers a rescan as soon as a watched directory changes.
+	Interval time.Duration
+	// BaselineFile keeps the baseline across restarts, so changes made
+	// while the agent was down are reported too. Empty keeps it in memory.
+	BaselineFile string
+}
+
+// FileState is what FIM compares between scans.
+type FileState struct {
+	Hash  string      `json:"sha256"`
+	Mode  fs.FileMode `json:"mode"`
+	UID   int         `json:"uid"`
+	GID   int         `json:"gid"`
+	Size  int64       `json:"size"`
+	MTime time.Time   `json:"mtime"`
+}
+
+// FIM actions.
+const (
+	FileAdded       = "added"
+	FileModified    = "modified"
+	FileDeleted     = "deleted"
+	FilePermissions = "permissions" // mode or owner changed, content not
+)
+
+// FIMEvent is a change of a monitored file. Old is nil for added files,
+// New for deleted ones.
+type FIMEvent struct {
+	Action   string
+	Path     string
+	Old, New *FileState
+}
+
+// String formats the event as the agent sends it:
+//
+//	fim: modified path="/etc/passwd" old_hash=... new_hash=... old_mode=-rw-r--r-- ...
+func (e FIMEvent) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "fim: %s path=%s", e.Action, strconv.Quote(e.Path))
+	for _, s := range []struct {
+		prefix string
+		state  *FileState
+	}{{"old_", e.Old}, {"new_", e.New}} {
+		if s.state == nil {
+			continue
+		}
+		st := s.state
+		fmt.Fprintf(&b, " %shash=%s %smode=%s %sowner=%d:%d %ssize=%d %smtime=%s",
+			s.prefix, st.Hash, s.prefix, st.Mode, s.prefix, st.UID, st.GID,
+			s.prefix, st.Size, s.prefix, st.MTime.UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// FIM watches files for changes of content, mode and owner against a
+// baseline of their states.
+type FIM struct {
+	cfg      FIMConfig
+	baseline map[string]FileState
+	loaded   bool // baseline came from BaselineFile
+}
+
+func NewFIM(cfg FIMConfig) *FIM {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultFIMInterval
+	}
+	f := &FIM{cfg: cfg, baseline: map[string]FileState{}}
+	if cfg.BaselineFile != "" {
+		data, err := os.ReadFile(cfg.BaselineFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			log.Printf("FIM baseline: %v", err)
+		default:
+			if err := json.Unmarshal(data, &f.baseline); err != nil {
+				log.Printf("FIM baseline %s: %v", cfg.BaselineFile, err)
+			} else {
+				f.loaded = true
+			}
+		}
+	}
+	return f
+}
+
+// Run scans the files until stop is closed. The first scan only builds
+// the baseline, unless it was loaded from BaselineFile. emit returns false
+// when the event could not be delivered because the agent is stopping.
+func (f *FIM) Run(stop <-chan struct{}, emit func(FIMEvent) bool) {
+	w, err := newDirWatcher()
+	if err != nil {
+		log.Printf("FIM: no change notifications, rescanning every %s: %v", f.cfg.Interval, err)
+	}
+	if w != nil {
+		defer w.Close()
+	}
+
+	f.scan(w, f.loaded, emit)
+
+	ticker := time.NewTicker(f.cfg.Interval)
+	defer ticker.Stop()
+	var changed <-chan struct{}
+	if w != nil {
+		changed = w.Changed()
+	}
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-changed:
+			if settle == nil {
+				settle = time.After(fimSettle)
+			}
+			continue
+		case <-settle:
+		case <-ticker.C:
+		}
+		settle = nil
+		if !f.scan(w, true, emit) {
+			return
+		}
+	}
+}
+
+// scan compares the files with the baseline and, if report is set, emits
+// the differences. The baseline is updated for every delivered event.
+func (f *FIM) scan(w dirWatcher, report bool, emit func(FIMEvent) bool) bool {
+	current, dirs := f.files()
+	if w != nil {
+		w.Watch(dirs)
+	}
+
+	var events []FIMEvent
+	for path, cur := range current {
+		old, ok := f.baseline[path]
+		switch {
+		case !ok:
+			events = append(events, FIMEvent{Action: FileAdded, Path: path, New: &cur})
+		case old.Hash != cur.Hash:
+			events = append(events, FIMEvent{Action: FileModified, Path: path, Old: &old, New: &cur})
+		case old.Mode != cur.Mode || old.UID != cur.UID || old.GID != cur.GID:
+			events = append(events, FIMEvent{Action: FilePermissions, Path: path, Old: &old, New: &cur})
+		default:
+			// Only the mtime or nothing changed.
+			f.baseline[path] = cur
+		}
+	}
+	for path, old := range f.baseline {
+		if _, ok := current[path]; !ok {
+			events = append(events, FIMEvent{Action: FileDeleted, Path: path, Old: &old})
+		}
+	}
+	slices.SortFunc(events, func(a, b FIMEvent) int { return strings.Compare(a.Path, b.Path) })
+
+	ok := true
+	for _, ev := range events {
+		if report && !emit(ev) {
+			ok = false
+			break
+		}
+		if ev.New != nil {
+			f.baseline[ev.Path] = *ev.New
+		} else {
+			delete(f.baseline, ev.Path)
+		}
+	}
+	f.save()
+	return ok
+}
+
+// files returns the states of the monitored files and the directories to
+// watch for them.
+func (f *FIM) files() (map[string]FileState, []string) {
+	states := map[string]FileState{}
+	dirs := map[string]bool{}
+	add := func(path string) {
+		st, err := stateOf(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("FIM %s: %v", path, err)
+			}
+			return
+		}
+		states[path] = st
+		dirs[filepath.Dir(path)] = true
+	}
+
+	for _, pattern := range f.cfg.Paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Printf("FIM pattern %s: %v", pattern, err)
+			continue
+		}
+		// A file that doesn't exist yet appears in a directory that may,
+		// or in each existing directory a glob like /home/*/.ssh matches.
+		if dir := filepath.Dir(pattern); !hasMeta(dir) {
+			dirs[dir] = true
+		} else if parents, err := filepath.Glob(dir); err == nil {
+			for _, d := range parents {
+				if fi, err := os.Stat(d); err == nil && fi.IsDir() {
+					dirs[d] = true
+				}
+			}
+		}
+		for _, path := range matches {
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if !fi.IsDir() {
+				add(path)
+				continue
+			}
+			dirs[path] = true
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				log.Printf("FIM %s: %v", path, err)
+				continue
+			}
+			for _, e := range entries {
+				if e.Type().IsRegular() {
+					add(filepath.Join(path, e.Name()))
+				}
+			}
+		}
+	}
+
+	list := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	return states, list
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+func stateOf(path string) (FileState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileState{}, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return FileState{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return FileState{}, err
+	}
+	uid, gid := ownerOf(fi)
+	return FileState{
+		Hash:  hex.EncodeToString(h.Sum(nil)),
+		Mode:  fi.Mode(),
+		UID:   uid,
+		GID:   gid,
+		Size:  fi.Size(),
+		MTime: fi.ModTime().UTC(),
+	}, nil
+}
+
+// dirWatcher reports changes in directories, without telling which.
+// Directories are watched rather than files so that files replaced by a
+// rename, as editors and tools like useradd do, stay watched.
+type dirWatcher interface {
+	Watch(dirs []string)
+	Changed() <-chan struct{}
+	Close() error
+}
+
+// save writes the baseline to BaselineFile, atomically like Checkpoints.
+func (f *FIM) save() {
+	if f.cfg.BaselineFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(f.baseline, "", "  ")
+	if err == nil {
+		tmp := f.cfg.BaselineFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, f.cfg.BaselineFile)
+		}
+	}
+	if err != nil {
+		log.Printf("FIM baseline save failed: %v", err)
+	}
+}
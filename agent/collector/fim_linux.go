@@ -0,0 +1,60 @@
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File
+	changed chan struct{}
+}
+
+func newDirWatcher() (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking fd goes to the runtime poller, so Close ends a Read
+	// waiting in the loop.
+	w := &inotifyWatcher{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), changed: make(chan struct{}, 1)}
+	go w.loop()
+	return w, nil
+}
+
+// Watch adds the directories; watching one again is a no-op for inotify.
+// Watches of deleted directories end by themselves.
+func (w *inotifyWatcher) Watch(dirs []string) {
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask); err != nil &&
+			!errors.Is(err, syscall.ENOENT) && !errors.Is(err, syscall.ENOTDIR) {
+			log.Printf("FIM watch %s: %v", dir, err)
+		}
+	}
+}
+
+func (w *inotifyWatcher) Changed() <-chan struct{} { return w.changed }
+
+func (w *inotifyWatcher) Close() error { return w.file.Close() }
+
+func (w *inotifyWatcher) loop() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := w.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package collector
+
+import "errors"
+
+// Without inotify, FIM rescans on its interval only.
+func newDirWatcher() (dirWatcher, error) {
+	return nil, errors.New("not supported on this platform")
+}
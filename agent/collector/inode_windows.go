@@ -0,0 +1,12 @@
+//go:build windows
+
+package collector
+
+import "os"
+
+// Windows has no inode numbers; rotation on restart is then detected by
+// size only.
+func inodeOf(os.FileInfo) uint64 { return 0 }
+
+// Files have no uid and gid; FIM then compares content and mode only.
+func ownerOf(os.FileInfo) (uid, gid int) { return -1, -1 }
@@ -0,0 +1,278 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	readChunk   = 32 * 1024
+	maxLineSize = 1 << 20
+)
+
+type TailConfig struct {
+	PollInterval time.Duration
+	// ReadRotated finishes the rotated "<path>.1" file on startup when the
+	// checkpointed file was rotated away while the agent was not running.
+	ReadRotated bool
+	// FromEnd starts files without a checkpoint at their end instead of
+	// their beginning.
+	FromEnd bool
+}
+
+// Position is the read position of a log file, keyed by its path in
+// Checkpoints.
+type Position struct {
+	Path string
+	Checkpoint
+}
+
+// Line is a line read by a Tailer with the position just after it. A line
+// with Mark set has no text and only moves the position, as after a
+// rotation or truncation.
+type Line struct {
+	Text string
+	Mark bool
+	Pos  Position
+}
+
+// Tailer follows one log file like `tail -F`: it reads appended lines,
+// reopens the path after rename or truncate rotation and resumes from
+// Checkpoints after a restart. It doesn't update Checkpoints itself: the
+// receiver of its lines saves their positions once they are safe, so a
+// crash never skips lines that were read but not yet spooled.
+type Tailer struct {
+	path string
+	cfg  TailConfig
+	cps  *Checkpoints
+
+	file    *os.File
+	inode   uint64
+	offset  int64 // end of the last emitted line
+	pending []byte
+	resumed bool
+}
+
+func NewTailer(path string, cps *Checkpoints, cfg TailConfig) *Tailer {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	return &Tailer{path: path, cfg: cfg, cps: cps}
+}
+
+// Run tails the file until stop is closed. emit is called for every
+// complete line and position mark and returns false when it could not be
+// delivered because the agent is stopping.
+func (t *Tailer) Run(stop <-chan struct{}, emit func(Line) bool) {
+	defer t.close()
+
+	ticker := time.NewTicker(t.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if !t.poll(emit) {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Tailer) poll(emit func(Line) bool) bool {
+	if t.file == nil {
+		ok, err := t.open(emit)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to open %s: %v", t.path, err)
+			}
+			return ok
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if !t.readLines(t.file, emit) {
+		return false
+	}
+
+	fi, err := os.Stat(t.path)
+	if err != nil {
+		// Renamed away and not recreated yet: keep draining the old file.
+		return true
+	}
+	cur, err := t.file.Stat()
+	if err != nil {
+		return true
+	}
+
+	switch {
+	case !os.SameFile(fi, cur):
+		// Rotated by rename. Anything written before the rename has been
+		// read above; a trailing line without newline is complete now.
+		if !t.flushLine(emit) {
+			return false
+		}
+		log.Printf("Log rotated: %s", t.path)
+		t.close()
+		t.offset = 0
+		return t.poll(emit)
+	case fi.Size() < t.offset+int64(len(t.pending)):
+		log.Printf("Log truncated: %s", t.path)
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Seek %s: %v", t.path, err)
+			t.close()
+			return true
+		}
+		t.offset = 0
+		t.pending = t.pending[:0]
+		return t.mark(emit)
+	}
+	return true
+}
+
+// open opens the path and positions it. The checkpoint is consulted only
+// on the first open; later opens follow a rotation and start at zero.
+func (t *Tailer) open(emit func(Line) bool) (bool, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return true, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return true, err
+	}
+	t.file = f
+	t.inode = inodeOf(fi)
+	t.pending = t.pending[:0]
+
+	if !t.resumed {
+		t.resumed = true
+		t.offset = 0
+
+		cp, ok := t.cps.Get(t.path)
+		switch {
+		case ok && cp.Inode == t.inode:
+			if cp.Offset <= fi.Size() {
+				t.offset = cp.Offset
+			}
+		case ok:
+			if t.cfg.ReadRotated && !t.readRotated(cp, emit) {
+				return false, nil
+			}
+		case t.cfg.FromEnd:
+			t.offset = fi.Size()
+		}
+	}
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		t.close()
+		return true, err
+	}
+	return t.mark(emit), nil
+}
+
+// readRotated finishes the file the checkpoint points at when it has been
+// rotated to "<path>.1" since the checkpoint was written.
+func (t *Tailer) readRotated(cp Checkpoint, emit func(Line) bool) bool {
+	rotated := t.path + ".1"
+	f, err := os.Open(rotated)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || inodeOf(fi) != cp.Inode || fi.Size() < cp.Offset {
+		return true
+	}
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		return true
+	}
+
+	log.Printf("Finishing rotated %s from offset %d", rotated, cp.Offset)
+	// Positions below are in the rotated file, so a restart before it is
+	// finished finds it again by its inode.
+	inode, offset := t.inode, t.offset
+	t.inode, t.offset = cp.Inode, cp.Offset
+	ok := t.readLines(f, emit) && t.flushLine(emit)
+	t.inode, t.offset = inode, offset
+	t.pending = t.pending[:0]
+	return ok
+}
+
+func (t *Tailer) readLines(f *os.File, emit func(Line) bool) bool {
+	buf := make([]byte, readChunk)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			t.pending = append(t.pending, buf[:n]...)
+			for {
+				i := bytes.IndexByte(t.pending, '\n')
+				if i < 0 {
+					break
+				}
+				line := t.pending[:i]
+				if !emit(t.line(line, int64(i+1))) {
+					return false
+				}
+				t.pending = t.pending[i+1:]
+				t.offset += int64(i + 1)
+			}
+			if len(t.pending) >= maxLineSize && !t.flushLine(emit) {
+				return false
+			}
+			t.pending = append([]byte(nil), t.pending...)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Read %s: %v", t.path, err)
+			}
+			break
+		}
+	}
+	return true
+}
+
+// flushLine emits a partial line that will not be completed, either
+// because its file was rotated or because it exceeds maxLineSize.
+func (t *Tailer) flushLine(emit func(Line) bool) bool {
+	if len(t.pending) == 0 {
+		return true
+	}
+	if !emit(t.line(t.pending, int64(len(t.pending)))) {
+		return false
+	}
+	t.offset += int64(len(t.pending))
+	t.pending = t.pending[:0]
+	return true
+}
+
+// line is the Line of text, which takes n bytes of the file from the
+// current offset.
+func (t *Tailer) line(text []byte, n int64) Line {
+	return Line{
+		Text: string(bytes.TrimSuffix(text, []byte("\r"))),
+		Pos:  Position{Path: t.path, Checkpoint: Checkpoint{Inode: t.inode, Offset: t.offset + n}},
+	}
+}
+
+// mark emits the current position.
+func (t *Tailer) mark(emit func(Line) bool) bool {
+	return emit(Line{Mark: true, Pos: Position{Path: t.path, Checkpoint: Checkpoint{Inode: t.inode, Offset: t.offset}}})
+}
+
+func (t *Tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
@@ -0,0 +1,360 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diskstats counts 512-byte sectors, whatever the device's sector size.
+const sectorSize = 512
+
+type MetricsConfig struct {
+	// ProcRoot and SysRoot are where procfs and sysfs are mounted;
+	// "/proc" and "/sys" by default. Agents in a container read the
+	// host's through bind mounts, tests through fixture directories.
+	ProcRoot string
+	SysRoot  string
+	// HostRoot is where the host's root filesystem is mounted, "/" by
+	// default; the mount points of the host are looked up below it.
+	HostRoot string
+}
+
+// Metrics samples host metrics from procfs, sysfs and statfs(2) of the
+// mounted filesystems.
+//
+// Names of the metrics, percentages unless noted:
+//
+//	cpu, cpu_user, cpu_system, cpu_iowait, cpu_steal
+//	mem, swap; mem_total, mem_available (bytes)
+//	load1, load5, load15
+//	net_rx_bytes_per_sec, net_tx_bytes_per_sec, net_rx_errors_per_sec, net_tx_errors_per_sec
+//	disk_read_bytes_per_sec, disk_write_bytes_per_sec, disk_util
+//	fs_used
+//
+// Network rates are summed over the interfaces but loopback and disk rates
+// over the whole disks; disk_util and fs_used are of the busiest disk and
+// fullest filesystem. Each also comes per device, as the name followed by
+// "." and the interface, disk or mount point, e.g. "fs_used./var".
+type Metrics struct {
+	cfg  MetricsConfig
+	prev *counters
+}
+
+// counters are the values that metrics are the rates of.
+type counters struct {
+	time time.Time
+	cpu  []uint64 // user nice system idle iowait irq softirq steal
+	net  map[string][4]uint64
+	disk map[string][3]uint64 // sectors read, sectors written, ms doing I/O
+}
+
+func NewMetrics(cfg MetricsConfig) *Metrics {
+	if cfg.ProcRoot == "" {
+		cfg.ProcRoot = "/proc"
+	}
+	if cfg.SysRoot == "" {
+		cfg.SysRoot = "/sys"
+	}
+	if cfg.HostRoot == "" {
+		cfg.HostRoot = "/"
+	}
+	return &Metrics{cfg: cfg}
+}
+
+// Sample returns the current metrics. CPU usage and rates are over the
+// time since the previous Sample, so the first one has gauges only. The
+// metrics of sources that could not be read are missing; err tells why.
+func (m *Metrics) Sample() (map[string]float64, error) {
+	out := map[string]float64{}
+	cur := &counters{time: time.Now()}
+	var errs []error
+	for _, read := range []func(*counters, map[string]float64) error{
+		m.readStat, m.readMeminfo, m.readLoadavg, m.readNetDev, m.readDiskstats, m.readFilesystems,
+	} {
+		if err := read(cur, out); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.prev != nil {
+		rates(m.prev, cur, out)
+	}
+	m.prev = cur
+	return out, errors.Join(errs...)
+}
+
+func (m *Metrics) proc(name string) string { return filepath.Join(m.cfg.ProcRoot, name) }
+
+// readStat reads the cpu line of /proc/stat:
+//
+//	cpu  user nice system idle iowait irq softirq steal guest guest_nice
+//
+// Guest time is part of user time already.
+func (m *Metrics) readStat(c *counters, _ map[string]float64) error {
+	return scanLines(m.proc("stat"), func(fields []string) bool {
+		if len(fields) < 9 || fields[0] != "cpu" {
+			return true
+		}
+		c.cpu = parseUints(fields[1:9])
+		return false
+	})
+}
+
+func (m *Metrics) readMeminfo(_ *counters, out map[string]float64) error {
+	kb := map[string]float64{}
+	err := scanLines(m.proc("meminfo"), func(fields []string) bool {
+		if len(fields) >= 2 {
+			if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				kb[strings.TrimSuffix(fields[0], ":")] = v
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	total := kb["MemTotal"]
+	if total == 0 {
+		return fmt.Errorf("%s: no MemTotal", m.proc("meminfo"))
+	}
+	avail, ok := kb["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14.
+		avail = kb["MemFree"] + kb["Buffers"] + kb["Cached"]
+	}
+	out["mem"] = percent(total-avail, total)
+	out["mem_total"] = total * 1024
+	out["mem_available"] = avail * 1024
+	if swap := kb["SwapTotal"]; swap > 0 {
+		out["swap"] = percent(swap-kb["SwapFree"], swap)
+	}
+	return nil
+}
+
+// readLoadavg reads /proc/loadavg: "0.52 0.58 0.59 1/1234 5678".
+func (m *Metrics) readLoadavg(_ *counters, out map[string]float64) error {
+	data, err := os.ReadFile(m.proc("loadavg"))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return fmt.Errorf("%s: unexpected format", m.proc("loadavg"))
+	}
+	for i, name := range []string{"load1", "load5", "load15"} {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.proc("loadavg"), err)
+		}
+		out[name] = v
+	}
+	return nil
+}
+
+// readNetDev reads the counters of /proc/net/dev, after two header lines:
+//
+//	eth0: rx_bytes rx_packets rx_errs rx_drop rx_fifo rx_frame rx_compressed rx_multicast tx_bytes tx_packets tx_errs ...
+func (m *Metrics) readNetDev(c *counters, _ map[string]float64) error {
+	c.net = map[string][4]uint64{}
+	return scanLines(m.proc("net/dev"), func(fields []string) bool {
+		// "eth0:123" when the counter is wide.
+		if len(fields) == 0 || !strings.Contains(fields[0], ":") {
+			return true
+		}
+		name, first, _ := strings.Cut(fields[0], ":")
+		if first != "" {
+			fields = append([]string{name + ":", first}, fields[1:]...)
+		}
+		if name == "lo" || len(fields) < 12 {
+			return true
+		}
+		v := parseUints(fields[1:12])
+		c.net[name] = [4]uint64{v[0], v[8], v[2], v[10]}
+		return true
+	})
+}
+
+// readDiskstats reads /proc/diskstats for the whole disks, which are the
+// devices in /sys/block. Device mapper and md devices are left out, their
+// I/O is counted on the disks below them:
+//
+//	8 0 sda reads merged sectors_read ms_reading writes merged sectors_written ms_writing in_flight ms_io ...
+func (m *Metrics) readDiskstats(c *counters, _ map[string]float64) error {
+	c.disk = map[string][3]uint64{}
+	return scanLines(m.proc("diskstats"), func(fields []string) bool {
+		if len(fields) < 13 {
+			return true
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			return true
+		}
+		dir := filepath.Join(m.cfg.SysRoot, "block", name)
+		if _, err := os.Stat(dir); err != nil {
+			return true
+		}
+		if slaves, _ := os.ReadDir(filepath.Join(dir, "slaves")); len(slaves) > 0 {
+			return true
+		}
+		v := parseUints(fields[3:13])
+		c.disk[name] = [3]uint64{v[2], v[6], v[9]}
+		return true
+	})
+}
+
+// readFilesystems takes the usage of the filesystems on block devices in
+// the mounts of init, which are the host's even when the agent runs in a
+// container with its own, once per device.
+func (m *Metrics) readFilesystems(_ *counters, out map[string]float64) error {
+	seen := map[string]bool{}
+	var errs []error
+	read := func(fields []string) bool {
+		if len(fields) < 3 {
+			return true
+		}
+		dev, dir, fstype := fields[0], unescapeMount(fields[1]), fields[2]
+		// Read-only images such as snaps are always full.
+		if !strings.HasPrefix(dev, "/") || fstype == "squashfs" || seen[dev] {
+			return true
+		}
+		seen[dev] = true
+		used, err := fsUsed(filepath.Join(m.cfg.HostRoot, dir))
+		if err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		if used >= 0 {
+			out["fs_used."+dir] = used
+			out["fs_used"] = max(out["fs_used"], used)
+		}
+		return true
+	}
+	err := scanLines(m.proc("1/mounts"), read)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		err = scanLines(m.proc("mounts"), read)
+	}
+	return errors.Join(append(errs, err)...)
+}
+
+// rates adds the metrics computed from the counters of two samples.
+func rates(prev, cur *counters, out map[string]float64) {
+	secs := cur.time.Sub(prev.time).Seconds()
+	if secs <= 0 {
+		return
+	}
+
+	if len(prev.cpu) == 8 && len(cur.cpu) == 8 {
+		var d [8]float64
+		var total float64
+		for i := range d {
+			if cur.cpu[i] >= prev.cpu[i] {
+				d[i] = float64(cur.cpu[i] - prev.cpu[i])
+			}
+			total += d[i]
+		}
+		if total > 0 {
+			out["cpu"] = percent(total-d[3]-d[4], total)
+			out["cpu_user"] = percent(d[0]+d[1], total)
+			out["cpu_system"] = percent(d[2]+d[5]+d[6], total)
+			out["cpu_iowait"] = percent(d[4], total)
+			out["cpu_steal"] = percent(d[7], total)
+		}
+	}
+
+	netNames := []string{"net_rx_bytes_per_sec", "net_tx_bytes_per_sec", "net_rx_errors_per_sec", "net_tx_errors_per_sec"}
+	for iface, c := range cur.net {
+		p, ok := prev.net[iface]
+		if !ok || counterWrapped(p[:], c[:]) {
+			continue
+		}
+		for i, name := range netNames {
+			r := float64(c[i]-p[i]) / secs
+			out[name+"."+iface] = r
+			out[name] += r
+		}
+	}
+
+	for disk, c := range cur.disk {
+		p, ok := prev.disk[disk]
+		if !ok || counterWrapped(p[:], c[:]) {
+			continue
+		}
+		read := float64(c[0]-p[0]) * sectorSize / secs
+		write := float64(c[1]-p[1]) * sectorSize / secs
+		util := min(percent(float64(c[2]-p[2])/1000, secs), 100)
+		out["disk_read_bytes_per_sec."+disk] = read
+		out["disk_write_bytes_per_sec."+disk] = write
+		out["disk_util."+disk] = util
+		out["disk_read_bytes_per_sec"] += read
+		out["disk_write_bytes_per_sec"] += write
+		out["disk_util"] = max(out["disk_util"], util)
+	}
+}
+
+// counterWrapped reports whether a counter went back, as after a driver
+// reload, which leaves no rate for the interval.
+func counterWrapped(prev, cur []uint64) bool {
+	for i := range cur {
+		if cur[i] < prev[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func percent(part, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return part / total * 100
+}
+
+func scanLines(path string, fn func(fields []string) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if !fn(strings.Fields(sc.Text())) {
+			break
+		}
+	}
+	return sc.Err()
+}
+
+// parseUints parses counters; ones that aren't numbers count as 0.
+func parseUints(fields []string) []uint64 {
+	v := make([]uint64, len(fields))
+	for i, f := range fields {
+		v[i], _ = strconv.ParseUint(f, 10, 64)
+	}
+	return v
+}
+
+// unescapeMount decodes the octal escapes of /proc/mounts, e.g. "\040"
+// for a space.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleFixtures takes a sample of testdata/proc/0 and one of
+// testdata/proc/1 ten seconds later.
+func sampleFixtures(t *testing.T) (first, second map[string]float64) {
+	t.Helper()
+	m := NewMetrics(MetricsConfig{ProcRoot: "testdata/proc/0", SysRoot: "testdata/sys"})
+	first, err := m.Sample()
+	if err != nil {
+		t.Fatalf("first Sample: %v", err)
+	}
+	m.prev.time = m.prev.time.Add(-10 * time.Second)
+	m.cfg.ProcRoot = "testdata/proc/1"
+	second, err = m.Sample()
+	if err != nil {
+		t.Fatalf("second Sample: %v", err)
+	}
+	return first, second
+}
+
+// wantMetrics checks metrics against want, within the error of a sample
+// taken a few milliseconds after the ten seconds.
+func wantMetrics(t *testing.T, got, want map[string]float64) {
+	t.Helper()
+	for name, w := range want {
+		g, ok := got[name]
+		if !ok {
+			t.Errorf("%s missing", name)
+			continue
+		}
+		if math.Abs(g-w) > math.Abs(w)*0.01 {
+			t.Errorf("%s = %g, want %g", name, g, w)
+		}
+	}
+}
+
+func TestMetricsGauges(t *testing.T) {
+	first, second := sampleFixtures(t)
+	for _, name := range []string{"cpu", "net_rx_bytes_per_sec", "disk_util"} {
+		if _, ok := first[name]; ok {
+			t.Errorf("first sample has rate %s", name)
+		}
+	}
+	for _, sample := range []map[string]float64{first, second} {
+		wantMetrics(t, sample, map[string]float64{
+			"mem":           75,
+			"mem_total":     8000000 * 1024,
+			"mem_available": 2000000 * 1024,
+			"swap":          25,
+			"load1":         0.5,
+			"load5":         0.4,
+			"load15":        0.3,
+		})
+	}
+}
+
+func TestMetricsCPU(t *testing.T) {
+	_, second := sampleFixtures(t)
+	// 2000 jiffies: 600 user, 200 system, 900 idle, 100 iowait, 50 irq,
+	// 50 softirq and 100 steal.
+	wantMetrics(t, second, map[string]float64{
+		"cpu":        50,
+		"cpu_user":   30,
+		"cpu_system": 15,
+		"cpu_iowait": 5,
+		"cpu_steal":  5,
+	})
+}
+
+func TestMetricsNetwork(t *testing.T) {
+	_, second := sampleFixtures(t)
+	// eth0 is in the wide form "eth0:1000000"; the counters of eth1 went
+	// back and lo is left out, so the totals are those of eth0.
+	wantMetrics(t, second, map[string]float64{
+		"net_rx_bytes_per_sec.eth0":  10000,
+		"net_tx_bytes_per_sec.eth0":  5000,
+		"net_rx_errors_per_sec.eth0": 0.2,
+		"net_rx_bytes_per_sec":       10000,
+		"net_tx_bytes_per_sec":       5000,
+		"net_rx_errors_per_sec":      0.2,
+	})
+	if v := second["net_tx_errors_per_sec.eth0"]; v != 0 {
+		t.Errorf("net_tx_errors_per_sec.eth0 = %g, want 0", v)
+	}
+	for name := range second {
+		if strings.HasSuffix(name, ".eth1") || strings.HasSuffix(name, ".lo") {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+}
+
+func TestMetricsDisks(t *testing.T) {
+	_, second := sampleFixtures(t)
+	// 20000 sectors read, 10000 written and 5 s of I/O on sda; its
+	// partitions, dm-0 and md0 on top of it and loop0 are left out.
+	wantMetrics(t, second, map[string]float64{
+		"disk_read_bytes_per_sec.sda":  20000 * 512 / 10,
+		"disk_write_bytes_per_sec.sda": 10000 * 512 / 10,
+		"disk_util.sda":                50,
+		"disk_read_bytes_per_sec":      20000 * 512 / 10,
+		"disk_write_bytes_per_sec":     10000 * 512 / 10,
+		"disk_util":                    50,
+	})
+	for name := range second {
+		for _, dev := range []string{".sda1", ".sda2", ".dm-0", ".md0", ".loop0"} {
+			if strings.HasSuffix(name, dev) {
+				t.Errorf("unexpected %s", name)
+			}
+		}
+	}
+}
+
+func TestMetricsFilesystems(t *testing.T) {
+	// The mounts of init, with / and a /srv data disk that only exists
+	// on the host; /home is a bind mount of /.
+	m := NewMetrics(MetricsConfig{ProcRoot: "testdata/host/proc", SysRoot: "testdata/sys", HostRoot: "testdata/host"})
+	sample := map[string]float64{}
+	err := m.readFilesystems(nil, sample)
+
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Op != "statfs" || pathErr.Path != filepath.Join("testdata/host", "srv data") {
+		t.Errorf("err = %v, want statfs of the data disk under the host root", err)
+	}
+	used, ok := sample["fs_used./"]
+	if !ok || used < 0 || used > 100 {
+		t.Errorf("fs_used./ = %g, %v", used, ok)
+	}
+	if sample["fs_used"] != used {
+		t.Errorf("fs_used = %g, want %g", sample["fs_used"], used)
+	}
+	for name := range sample {
+		if strings.HasPrefix(name, "fs_used.") && name != "fs_used./" {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+}
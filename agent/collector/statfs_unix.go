@@ -0,0 +1,23 @@
+//go:build !windows
+
+package collector
+
+import (
+	"os"
+	"syscall"
+)
+
+// fsUsed returns the used percentage of the filesystem at dir as df shows
+// it, of the space not reserved for root; -1 for filesystems without
+// blocks.
+func fsUsed(dir string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: dir, Err: err}
+	}
+	used := uint64(st.Blocks) - uint64(st.Bfree)
+	if total := used + uint64(st.Bavail); total > 0 {
+		return percent(float64(used), float64(total)), nil
+	}
+	return -1, nil
+}
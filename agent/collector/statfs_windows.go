@@ -0,0 +1,9 @@
+//go:build windows
+
+package collector
+
+import "errors"
+
+func fsUsed(string) (float64, error) {
+	return 0, errors.New("statfs: not supported on windows")
+}
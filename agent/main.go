@@ -2,25 +2,48 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"gopkg.in/yaml.v3"
+
+	"siem-agent/collector"
+	"siem-agent/sender"
 )
 
 type Config struct {
-	ServerURL string   `yaml:"server_url"`
-	LogFiles  []string `yaml:"log_files"`
-	BatchSize int      `yaml:"batch_size"`
+	ServerURL       string        `yaml:"server_url"`
+	AgentID         string        `yaml:"agent_id"`
+	LogFiles        []string      `yaml:"log_files"`
+	AuditLog        string        `yaml:"audit_log"`
+	FIMPaths        []string      `yaml:"fim_paths"`
+	FIMInterval     time.Duration `yaml:"fim_interval"`
+	FIMBaseline     string        `yaml:"fim_baseline"`
+	MetricsInterval time.Duration `yaml:"metrics_interval"`
+	ProcRoot        string        `yaml:"proc_root"`
+	SysRoot         string        `yaml:"sys_root"`
+	HostRoot        string        `yaml:"host_root"`
+	BatchSize       int           `yaml:"batch_size"`
+	CheckpointFile  string        `yaml:"checkpoint_file"`
+	PollInterval    time.Duration `yaml:"poll_interval"`
+	ReadRotated     bool          `yaml:"read_rotated"`
+	StartAtEnd      bool          `yaml:"start_at_end"`
+	SpoolDir        string        `yaml:"spool_dir"`
+	SpoolMaxBytes   int64         `yaml:"spool_max_bytes"`
+	SpoolMaxAge     time.Duration `yaml:"spool_max_age"`
+	ReconnectMin    time.Duration `yaml:"reconnect_min"`
+	ReconnectMax    time.Duration `yaml:"reconnect_max"`
+	AckWindow       int           `yaml:"ack_window"`
+	AckTimeout      time.Duration `yaml:"ack_timeout"`
 }
 
 type LogEntry struct {
@@ -30,14 +53,25 @@ type LogEntry struct {
 	Message   string `json:"msg"`
 	Level     string `json:"level"`
 	Pid       int    `json:"pid,omitempty"`
+	// Metrics are set on the entries of source "metrics".
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+
+	// pos is the log position to checkpoint once the entry is spooled.
+	// A mark only carries pos and is not sent.
+	pos  collector.Position
+	mark bool
 }
 
 type Agent struct {
-	config Config
-	host   string
-	conn   *websocket.Conn
-	logCh  chan LogEntry
-	stopCh chan struct{}
+	config      Config
+	host        string
+	sender      *sender.Sender
+	checkpoints *collector.Checkpoints
+	logCh       chan LogEntry
+	stopCh      chan struct{}
+	senderDone  chan struct{}
+	collectors  sync.WaitGroup
+	stopOnce    sync.Once
 }
 
 func main() {
@@ -50,7 +84,7 @@ func main() {
 	}
 	defer agent.Close() // ✅ Теперь работает!
 
-	log.Printf("SIEM Agent started on %s -> %s", agent.host, agent.config.ServerURL)
+	log.Printf("SIEM Agent %s started on %s -> %s", agent.config.AgentID, agent.host, agent.config.ServerURL)
 	agent.Run()
 }
 
@@ -73,30 +107,94 @@ func NewAgent(configPath string) (*Agent, error) {
 	if len(config.LogFiles) == 0 {
 		config.LogFiles = []string{"/var/log/auth.log", "/var/log/syslog"}
 	}
+	if config.AuditLog == "" {
+		config.AuditLog = "/var/log/audit/audit.log"
+	}
+	if len(config.FIMPaths) == 0 {
+		config.FIMPaths = defaultFIMPaths
+	}
+	if config.FIMBaseline == "" {
+		config.FIMBaseline = "fim_baseline.json"
+	}
+	if config.MetricsInterval <= 0 {
+		config.MetricsInterval = 30 * time.Second
+	}
+	if config.CheckpointFile == "" {
+		config.CheckpointFile = "checkpoints.json"
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = time.Second
+	}
+	if config.SpoolDir == "" {
+		config.SpoolDir = "spool"
+	}
+	if config.SpoolMaxBytes == 0 {
+		config.SpoolMaxBytes = 100 << 20
+	}
+	if config.SpoolMaxAge == 0 {
+		config.SpoolMaxAge = 24 * time.Hour
+	}
 
-	dialer := websocket.DefaultDialer
-	conn, _, err := dialer.Dial(config.ServerURL, nil)
+	checkpoints, err := collector.LoadCheckpoints(config.CheckpointFile)
 	if err != nil {
-		return nil, fmt.Errorf("websocket dial: %w", err)
+		return nil, err
 	}
 
+	spool, err := sender.OpenSpool(config.SpoolDir, config.SpoolMaxBytes, config.SpoolMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if n := spool.Len(); n > 0 {
+		log.Printf("Spool has %d undelivered batches", n)
+	}
+	if config.AgentID == "" {
+		if config.AgentID, err = sender.AgentID(config.SpoolDir, host); err != nil {
+			return nil, err
+		}
+	}
+
+	// The sender connects in the background and keeps retrying, so the
+	// agent starts collecting even while the server is down.
+	snd := sender.New(sender.Config{
+		ServerURL:    config.ServerURL,
+		AgentID:      config.AgentID,
+		Host:         host,
+		Window:       config.AckWindow,
+		AckTimeout:   config.AckTimeout,
+		ReconnectMin: config.ReconnectMin,
+		ReconnectMax: config.ReconnectMax,
+	}, spool)
+
 	return &Agent{
-		config: config,
-		host:   host,
-		conn:   conn,
-		logCh:  make(chan LogEntry, 1000),
-		stopCh: make(chan struct{}),
+		config:      config,
+		host:        host,
+		sender:      snd,
+		checkpoints: checkpoints,
+		logCh:       make(chan LogEntry, 1000),
+		stopCh:      make(chan struct{}),
+		senderDone:  make(chan struct{}),
 	}, nil
 }
 
 func (a *Agent) Run() {
 	// Запуск коллекторов логов
 	for _, logFile := range a.config.LogFiles {
+		a.collectors.Add(1)
 		go a.collectLogs(logFile)
 	}
+	if a.config.AuditLog != "off" {
+		a.collectors.Add(1)
+		go a.collectAudit(a.config.AuditLog)
+	}
+	if !slices.Equal(a.config.FIMPaths, []string{"off"}) {
+		a.collectors.Add(1)
+		go a.collectFIM()
+	}
 
 	// Периодическая отправка метрик
+	a.collectors.Add(2)
 	go a.collectMetrics()
+	go a.saveCheckpoints()
 
 	// Graceful shutdown
 	sigCh := make(chan os.Signal, 1)
@@ -110,46 +208,190 @@ func (a *Agent) Run() {
 }
 
 func (a *Agent) collectLogs(filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Printf("Failed to open %s: %v", filename, err)
-		return
+	defer a.collectors.Done()
+
+	tailer := collector.NewTailer(filename, a.checkpoints, collector.TailConfig{
+		PollInterval: a.config.PollInterval,
+		ReadRotated:  a.config.ReadRotated,
+		FromEnd:      a.config.StartAtEnd,
+	})
+	tailer.Run(a.stopCh, func(line collector.Line) bool {
+		select {
+		case a.logCh <- a.lineEntry(filename, line):
+			return true
+		case <-a.stopCh:
+			return false
+		}
+	})
+}
+
+func (a *Agent) lineEntry(source string, line collector.Line) LogEntry {
+	if line.Mark {
+		return LogEntry{pos: line.Pos, mark: true}
 	}
-	defer file.Close()
+	return LogEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Host:      a.host,
+		Source:    source,
+		Message:   line.Text,
+		Level:     parseLevel(line.Text),
+		pos:       line.Pos,
+	}
+}
+
+// auditFlushAge is how long an auditd event without EOE record waits for
+// more records.
+const auditFlushAge = 2 * time.Second
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+// collectAudit tails the auditd log and sends each event, with all its
+// records, as one entry.
+func (a *Agent) collectAudit(filename string) {
+	defer a.collectors.Done()
+
+	// On stop, the events still pending are sent regardless: batchSender
+	// drains logCh until all collectors are done.
+	stopping := false
+	send := func(event collector.Line) bool {
+		entry := a.lineEntry(filename, event)
+		if stopping {
+			a.logCh <- entry
+			return true
+		}
 		select {
+		case a.logCh <- entry:
+			return true
 		case <-a.stopCh:
-			return
-		default:
-			line := scanner.Text()
-			level := parseLevel(line)
-			entry := LogEntry{
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-				Host:      a.host,
-				Source:    filename,
-				Message:   line,
-				Level:     level,
+			return false
+		}
+	}
+	asm := collector.NewAuditAssembler(send)
+
+	done := make(chan struct{})
+	flusherDone := make(chan struct{})
+	go func() {
+		defer close(flusherDone)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				asm.Flush(auditFlushAge)
+			case <-done:
+				return
 			}
-			a.logCh <- entry
+		}
+	}()
+
+	tailer := collector.NewTailer(filename, a.checkpoints, collector.TailConfig{
+		PollInterval: a.config.PollInterval,
+		ReadRotated:  a.config.ReadRotated,
+		FromEnd:      a.config.StartAtEnd,
+	})
+	tailer.Run(a.stopCh, asm.Add)
+	close(done)
+	<-flusherDone
+	stopping = true
+	asm.Flush(0)
+}
+
+// defaultFIMPaths are the files whose changes grant accounts, root rights
+// or SSH access.
+var defaultFIMPaths = []string{
+	"/etc/passwd",
+	"/etc/shadow",
+	"/etc/group",
+	"/etc/sudoers",
+	"/etc/sudoers.d",
+	"/etc/ssh/sshd_config",
+	"/root/.ssh/authorized_keys",
+	"/home/*/.ssh/authorized_keys",
+}
+
+// collectFIM sends a change of a monitored file as an entry of source
+// "fim".
+func (a *Agent) collectFIM() {
+	defer a.collectors.Done()
+
+	fim := collector.NewFIM(collector.FIMConfig{
+		Paths:        a.config.FIMPaths,
+		Interval:     a.config.FIMInterval,
+		BaselineFile: a.config.FIMBaseline,
+	})
+	fim.Run(a.stopCh, func(ev collector.FIMEvent) bool {
+		entry := LogEntry{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Host:      a.host,
+			Source:    "fim",
+			Message:   ev.String(),
+			Level:     "warn",
+		}
+		select {
+		case a.logCh <- entry:
+			return true
+		case <-a.stopCh:
+			return false
+		}
+	})
+}
+
+// saveCheckpoints persists the positions batchSender committed.
+func (a *Agent) saveCheckpoints() {
+	defer a.collectors.Done()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.checkpoints.Save(); err != nil {
+				log.Printf("Checkpoint save failed: %v", err)
+			}
+		case <-a.stopCh:
+			return
 		}
 	}
 }
 
+// collectMetrics sends the host metrics every MetricsInterval, starting
+// one interval after start so that the first entry has rates already.
 func (a *Agent) collectMetrics() {
-	ticker := time.NewTicker(30 * time.Second)
+	defer a.collectors.Done()
+
+	metrics := collector.NewMetrics(collector.MetricsConfig{
+		ProcRoot: a.config.ProcRoot,
+		SysRoot:  a.config.SysRoot,
+		HostRoot: a.config.HostRoot,
+	})
+	metrics.Sample()
+
+	ticker := time.NewTicker(a.config.MetricsInterval)
 	defer ticker.Stop()
 
+	var lastErr string
 	for {
 		select {
 		case <-ticker.C:
+			values, err := metrics.Sample()
+			// Sources missing on this host fail every time; say so once.
+			errText := ""
+			if err != nil {
+				errText = err.Error()
+				if errText != lastErr {
+					log.Printf("Metrics: %v", err)
+				}
+			}
+			lastErr = errText
+			if len(values) == 0 {
+				continue
+			}
 			entry := LogEntry{
 				Timestamp: time.Now().UTC().Format(time.RFC3339),
 				Host:      a.host,
 				Source:    "metrics",
-				Message:   fmt.Sprintf("CPU:%.1f%% MEM:%.1f%%", getCPU(), getMem()),
+				Message:   metricsSummary(values),
 				Level:     "info",
+				Metrics:   values,
 			}
 			select {
 			case a.logCh <- entry:
@@ -162,52 +404,92 @@ func (a *Agent) collectMetrics() {
 	}
 }
 
+// metricsSummary is the message of a metrics entry, for reading; the
+// server uses the values.
+func metricsSummary(values map[string]float64) string {
+	var b strings.Builder
+	b.WriteString("metrics:")
+	for _, name := range []string{"cpu", "mem", "swap", "load1", "disk_util", "fs_used"} {
+		if v, ok := values[name]; ok {
+			fmt.Fprintf(&b, " %s=%.1f", name, v)
+		}
+	}
+	return b.String()
+}
+
+// batchSender runs until logCh is closed by Stop, so every entry the
+// collectors handed over is spooled before the checkpoints are saved.
+// The log positions of the entries are committed to the checkpoints only
+// once their batch is in the spool.
 func (a *Agent) batchSender() {
+	defer close(a.senderDone)
+
 	batch := make([]LogEntry, 0, a.config.BatchSize)
+	var positions []collector.Position
+	flush := func() {
+		if len(batch) > 0 && !a.sendBatch(batch) {
+			positions = positions[:0]
+		}
+		for _, p := range positions {
+			a.checkpoints.Set(p.Path, p.Checkpoint)
+		}
+		batch, positions = batch[:0], positions[:0]
+	}
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case entry := <-a.logCh:
-			batch = append(batch, entry)
-			if len(batch) >= a.config.BatchSize {
-				a.sendBatch(batch)
-				batch = batch[:0]
+		case entry, ok := <-a.logCh:
+			if !ok {
+				flush()
+				return
 			}
-		case <-ticker.C:
-			if len(batch) > 0 {
-				a.sendBatch(batch)
-				batch = batch[:0]
+			if entry.pos.Path != "" {
+				positions = append(positions, entry.pos)
 			}
-		case <-a.stopCh:
-			if len(batch) > 0 {
-				a.sendBatch(batch)
+			switch {
+			case entry.mark && len(batch) == 0:
+				// Everything before it is spooled already.
+				flush()
+			case !entry.mark:
+				batch = append(batch, entry)
+				if len(batch) >= a.config.BatchSize {
+					flush()
+				}
 			}
-			return
+		case <-ticker.C:
+			flush()
 		}
 	}
 }
 
-func (a *Agent) sendBatch(batch []LogEntry) {
-	data, _ := json.Marshal(map[string]interface{}{
-		"type":  "logs",
-		"host":  a.host,
-		"batch": batch,
-	})
-
-	if err := a.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		log.Printf("Send failed: %v", err)
-		// Reconnect logic можно добавить позже
+func (a *Agent) sendBatch(batch []LogEntry) bool {
+	data, err := json.Marshal(batch)
+	if err == nil {
+		err = a.sender.Send(data)
+	}
+	if err != nil {
+		log.Printf("Batch lost: %v", err)
+		return false
 	}
+	return true
 }
 
 func (a *Agent) Stop() {
-	close(a.stopCh)
-	if a.conn != nil {
-		a.conn.Close()
-	}
-	log.Println("Agent stopped")
+	a.stopOnce.Do(func() {
+		close(a.stopCh)
+		a.collectors.Wait()
+		close(a.logCh)
+		<-a.senderDone
+
+		a.sender.Close()
+
+		if err := a.checkpoints.Save(); err != nil {
+			log.Printf("Checkpoint save failed: %v", err)
+		}
+		log.Println("Agent stopped")
+	})
 }
 
 // ✅ Добавлен недостающий метод
@@ -229,6 +511,3 @@ func parseLevel(line string) string {
 		return "info"
 	}
 }
-
-func getCPU() float64 { return 12.5 } // Заглушка
-func getMem() float64 { return 67.3 } // Заглушка
@@ -0,0 +1,77 @@
+package sender
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// protocolVersion must match the server's ingest.Version.
+const protocolVersion = 2
+
+type envelope struct {
+	Type     string          `json:"type"`
+	Version  int             `json:"v"`
+	AgentID  string          `json:"agent_id"`
+	Seq      uint64          `json:"seq"`
+	Checksum string          `json:"checksum"`
+	Host     string          `json:"host"`
+	Batch    json.RawMessage `json:"batch"`
+}
+
+type reply struct {
+	Type      string `json:"type"`
+	Seq       uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate"`
+	Error     string `json:"error"`
+	Retry     bool   `json:"retry"`
+}
+
+func encodeBatch(agentID, host string, seq uint64, payload []byte) ([]byte, error) {
+	sum := sha256.Sum256(payload)
+	return json.Marshal(envelope{
+		Type:     "logs",
+		Version:  protocolVersion,
+		AgentID:  agentID,
+		Seq:      seq,
+		Checksum: hex.EncodeToString(sum[:]),
+		Host:     host,
+		Batch:    payload,
+	})
+}
+
+// AgentID returns the agent ID stored in dir, creating one from host and a
+// random suffix on first use. It lives next to the spool because sequence
+// numbers are only unique together with it: losing the spool state also
+// starts a new ID, so the server won't take fresh batches for duplicates.
+func AgentID(dir, host string) (string, error) {
+	path := filepath.Join(dir, "agent_id")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read agent id: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	id := host + "-" + hex.EncodeToString(suffix)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("write agent id: %w", err)
+	}
+	if err := writeAtomic(path, []byte(id)); err != nil {
+		return "", fmt.Errorf("write agent id: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,192 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolExt    = ".batch"
+	nextSeqFile = "next_seq"
+)
+
+// Spool is a bounded on-disk FIFO of encoded batches. Every batch is one
+// file named by its sequence number, which is also the batch's protocol
+// sequence number, so it is never reused: the next one is persisted even
+// when the spool is empty. When the size or age limit is exceeded the
+// oldest batches are dropped.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	entries []spoolEntry
+	size    int64
+	nextSeq uint64
+}
+
+type spoolEntry struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+func OpenSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes, maxAge: maxAge, nextSeq: 1}
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Leftover from a crash during Append.
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		s.entries = append(s.entries, spoolEntry{seq: seq, size: info.Size(), created: info.ModTime()})
+		s.size += info.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].seq < s.entries[j].seq })
+
+	if data, err := os.ReadFile(filepath.Join(dir, nextSeqFile)); err == nil {
+		if seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err == nil && seq > s.nextSeq {
+			s.nextSeq = seq
+		}
+	}
+
+	s.mu.Lock()
+	s.evictLocked()
+	s.mu.Unlock()
+	return s, nil
+}
+
+// Append stores a batch and returns its sequence number.
+func (s *Spool) Append(data []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.nextSeq
+	if err := writeAtomic(filepath.Join(s.dir, nextSeqFile), []byte(strconv.FormatUint(seq+1, 10))); err != nil {
+		return 0, fmt.Errorf("spool write: %w", err)
+	}
+	s.nextSeq++
+
+	if err := writeAtomic(s.path(seq), data); err != nil {
+		return 0, fmt.Errorf("spool write: %w", err)
+	}
+
+	s.entries = append(s.entries, spoolEntry{seq: seq, size: int64(len(data)), created: time.Now()})
+	s.size += int64(len(data))
+	s.evictLocked()
+	return seq, nil
+}
+
+// Next returns the oldest batch with a sequence number above after.
+func (s *Spool) Next(after uint64) (uint64, []byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictLocked()
+	for i := 0; i < len(s.entries); {
+		e := s.entries[i]
+		if e.seq <= after {
+			i++
+			continue
+		}
+		data, err := os.ReadFile(s.path(e.seq))
+		if err == nil {
+			return e.seq, data, true
+		}
+		log.Printf("Spool: dropping unreadable batch %d: %v", e.seq, err)
+		s.removeLocked(i)
+	}
+	return 0, nil, false
+}
+
+func (s *Spool) Remove(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.entries {
+		if e.seq == seq {
+			s.removeLocked(i)
+			return
+		}
+	}
+}
+
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *Spool) evictLocked() {
+	dropped := 0
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		tooBig := s.maxBytes > 0 && s.size > s.maxBytes
+		tooOld := s.maxAge > 0 && time.Since(e.created) > s.maxAge
+		if !tooBig && !tooOld {
+			break
+		}
+		s.removeLocked(0)
+		dropped++
+	}
+	if dropped > 0 {
+		log.Printf("Spool: evicted %d oldest batches (limits %d bytes, %s)", dropped, s.maxBytes, s.maxAge)
+	}
+}
+
+func (s *Spool) removeLocked(i int) {
+	e := s.entries[i]
+	if err := os.Remove(s.path(e.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Spool: remove batch %d: %v", e.seq, err)
+	}
+	s.size -= e.size
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
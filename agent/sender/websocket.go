@@ -0,0 +1,304 @@
+package sender
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	// closeGrace is how long Close waits for outstanding acks.
+	closeGrace = 5 * time.Second
+)
+
+type Config struct {
+	ServerURL string
+	AgentID   string
+	Host      string
+	// Window is the maximum number of batches sent but not yet acked.
+	Window int
+	// AckTimeout drops the connection when the oldest outstanding batch
+	// is not acked in time; unacked batches are resent after reconnect.
+	AckTimeout   time.Duration
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+}
+
+// Sender delivers batches over a websocket with at-least-once semantics.
+// Every batch is written to the spool first and only removed once the
+// server acks its sequence number. After a reconnect all unacked batches
+// are resent oldest first; the server drops the ones it already stored.
+type Sender struct {
+	cfg   Config
+	spool *Spool
+
+	wake     chan struct{}
+	replies  chan connReply
+	connLost chan *websocket.Conn
+	done     chan struct{}
+	retry    *time.Timer
+
+	conn         *websocket.Conn
+	sent         uint64 // highest sequence number written on conn
+	inflight     int
+	lastProgress time.Time
+	backoff      time.Duration
+	retrying     bool
+}
+
+type connReply struct {
+	conn  *websocket.Conn
+	reply reply
+}
+
+func New(cfg Config, spool *Spool) *Sender {
+	if cfg.Window <= 0 {
+		cfg.Window = 32
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = 30 * time.Second
+	}
+	if cfg.ReconnectMin <= 0 {
+		cfg.ReconnectMin = time.Second
+	}
+	if cfg.ReconnectMax < cfg.ReconnectMin {
+		cfg.ReconnectMax = time.Minute
+	}
+	s := &Sender{
+		cfg:      cfg,
+		spool:    spool,
+		wake:     make(chan struct{}, 1),
+		replies:  make(chan connReply),
+		connLost: make(chan *websocket.Conn),
+		done:     make(chan struct{}),
+		retry:    time.NewTimer(0),
+		backoff:  cfg.ReconnectMin,
+		retrying: true,
+	}
+	go s.run()
+	return s
+}
+
+// Send writes a batch payload to the spool and wakes the delivery loop.
+// Once it returns nil the batch survives a crash of the agent. Send must
+// not be called after Close.
+func (s *Sender) Send(payload []byte) error {
+	if _, err := s.spool.Append(payload); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close waits briefly for outstanding acks and closes the connection.
+// Unacked batches stay in the spool.
+func (s *Sender) Close() {
+	close(s.wake)
+	<-s.done
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	defer s.retry.Stop()
+
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+
+	wake := s.wake
+	var closing <-chan time.Time
+
+	for {
+		if wake == nil && (s.conn == nil || s.inflight == 0) {
+			s.disconnect()
+			return
+		}
+
+		select {
+		case _, ok := <-wake:
+			if !ok {
+				wake = nil
+				closing = time.After(closeGrace)
+				continue
+			}
+			s.pump()
+		case r := <-s.replies:
+			// Ignore replies from connections already replaced.
+			if r.conn == s.conn {
+				s.handleReply(r.reply)
+				s.pump()
+			}
+		case conn := <-s.connLost:
+			if conn == s.conn {
+				log.Printf("Connection to %s lost", s.cfg.ServerURL)
+				s.reconnect()
+			}
+		case <-s.retry.C:
+			s.retrying = false
+			if s.conn == nil && wake != nil {
+				if s.connect() {
+					s.pump()
+				} else {
+					s.scheduleRetry()
+				}
+			}
+		case <-check.C:
+			if s.conn != nil && s.inflight > 0 && time.Since(s.lastProgress) > s.cfg.AckTimeout {
+				log.Printf("No ack from %s for %s", s.cfg.ServerURL, s.cfg.AckTimeout)
+				s.reconnect()
+			}
+		case <-closing:
+			if s.inflight > 0 {
+				log.Printf("%d batches unacked at shutdown, kept in spool", s.inflight)
+			}
+			s.disconnect()
+			return
+		}
+	}
+}
+
+// pump sends spooled batches in order until the window is full.
+func (s *Sender) pump() {
+	for s.conn != nil && s.inflight < s.cfg.Window {
+		seq, payload, ok := s.spool.Next(s.sent)
+		if !ok {
+			return
+		}
+		if !s.send(seq, payload) {
+			return
+		}
+		s.sent = seq
+	}
+}
+
+func (s *Sender) send(seq uint64, payload []byte) bool {
+	msg, err := encodeBatch(s.cfg.AgentID, s.cfg.Host, seq, payload)
+	if err != nil {
+		log.Printf("Encode batch %d: %v", seq, err)
+		return false
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := s.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Printf("Send failed: %v", err)
+		s.reconnect()
+		return false
+	}
+
+	if s.inflight == 0 {
+		s.lastProgress = time.Now()
+	}
+	s.inflight++
+	return true
+}
+
+func (s *Sender) handleReply(r reply) {
+	if s.inflight > 0 {
+		s.inflight--
+	}
+	s.lastProgress = time.Now()
+
+	switch {
+	case r.Type == "ack":
+		if r.Duplicate {
+			// A resend of a batch the server stored before the
+			// connection dropped.
+			log.Printf("Batch %d was already stored", r.Seq)
+		}
+		s.spool.Remove(r.Seq)
+	case r.Type == "nack" && r.Retry:
+		// Usually a server-side storage problem: back off and resend
+		// everything unacked after reconnecting.
+		log.Printf("Batch %d nacked, will retry: %s", r.Seq, r.Error)
+		s.reconnect()
+	case r.Type == "nack":
+		log.Printf("Batch %d rejected by server: %s", r.Seq, r.Error)
+		s.spool.Remove(r.Seq)
+	}
+}
+
+func (s *Sender) connect() bool {
+	dialer := websocket.Dialer{HandshakeTimeout: writeTimeout}
+	conn, _, err := dialer.Dial(s.cfg.ServerURL, nil)
+	if err != nil {
+		log.Printf("Connect %s failed: %v (retry in %s)", s.cfg.ServerURL, err, s.backoff)
+		return false
+	}
+
+	log.Printf("Connected to %s", s.cfg.ServerURL)
+	if n := s.spool.Len(); n > 0 {
+		log.Printf("Resending %d unacked batches", n)
+	}
+	s.conn = conn
+	s.sent = 0
+	s.inflight = 0
+	s.backoff = s.cfg.ReconnectMin
+	go s.readLoop(conn)
+	return true
+}
+
+// readLoop decodes acks and reports when the connection dies.
+func (s *Sender) readLoop(conn *websocket.Conn) {
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case s.connLost <- conn:
+			case <-s.done:
+			}
+			return
+		}
+
+		var r reply
+		if err := json.Unmarshal(msg, &r); err != nil {
+			continue
+		}
+		select {
+		case s.replies <- connReply{conn: conn, reply: r}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Sender) reconnect() {
+	s.disconnect()
+	s.scheduleRetry()
+}
+
+func (s *Sender) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.inflight = 0
+}
+
+// scheduleRetry arms the reconnect timer with exponential backoff and
+// ±20% jitter so agents don't reconnect in lockstep after a deploy.
+func (s *Sender) scheduleRetry() {
+	if s.conn != nil || s.retrying {
+		return
+	}
+	s.retrying = true
+	d := s.backoff
+	d += time.Duration((rand.Float64()*0.4 - 0.2) * float64(d))
+
+	s.backoff *= 2
+	if s.backoff > s.cfg.ReconnectMax {
+		s.backoff = s.cfg.ReconnectMax
+	}
+
+	s.retry.Stop()
+	select {
+	case <-s.retry.C:
+	default:
+	}
+	s.retry.Reset(d)
+}
@@ -1,37 +1,78 @@
+import { useState } from 'react'
+import { useQuery } from '@tanstack/react-query'
 import { ResponsiveContainer, LineChart, Line, XAxis, YAxis, Tooltip, CartesianGrid } from 'recharts'
 
-const mockData = [
-  { time: '14:00', cpu: 12, mem: 45 },
-  { time: '14:02', cpu: 25, mem: 52 },
-  { time: '14:04', cpu: 18, mem: 60 },
-  { time: '14:06', cpu: 35, mem: 48 },
-  { time: '14:08', cpu: 22, mem: 55 },
+const API = 'http://localhost:8080/api/v1'
+
+const lines = [
+  { metric: 'cpu', name: 'CPU %', color: '#3B82F6' },
+  { metric: 'mem', name: 'Memory %', color: '#10B981' },
 ]
 
+// rows merges the series of /metrics/range into one row per timestamp.
+function rows(series) {
+  const byTime = new Map()
+  for (const s of series) {
+    for (const p of s.points) {
+      const row = byTime.get(p.ts) || { ts: p.ts, time: new Date(p.ts).toLocaleTimeString() }
+      row[s.metric] = Number(p.avg.toFixed(1))
+      byTime.set(p.ts, row)
+    }
+  }
+  return [...byTime.values()].sort((a, b) => a.ts.localeCompare(b.ts))
+}
+
 export default function MetricsChart() {
+  const [host, setHost] = useState('')
+
+  const { data: namesData } = useQuery({
+    queryKey: ['metric-names'],
+    queryFn: () => fetch(`${API}/metrics`).then(res => res.json()),
+    refetchInterval: 60000,
+  })
+  const hosts = [...new Set((namesData?.metrics || []).map(m => m.host))]
+  const selected = host || hosts[0] || ''
+
+  const { data: rangeData } = useQuery({
+    queryKey: ['metric-range', selected],
+    queryFn: () => {
+      const metrics = lines.map(l => l.metric).join(',')
+      return fetch(`${API}/metrics/range?host=${encodeURIComponent(selected)}&metric=${metrics}`).then(res => res.json())
+    },
+    enabled: selected !== '',
+    refetchInterval: 30000,
+  })
+  const data = rows(rangeData?.series || [])
+
   return (
     <div className="bg-gray-800 rounded-xl p-6 shadow-2xl">
-      <h2 className="text-2xl font-bold mb-6">📊 System Metrics</h2>
+      <div className="flex justify-between items-center mb-6">
+        <h2 className="text-2xl font-bold">📊 System Metrics</h2>
+        <select
+          className="bg-gray-700 rounded px-3 py-1"
+          value={selected}
+          onChange={e => setHost(e.target.value)}
+        >
+          {hosts.map(h => <option key={h} value={h}>{h}</option>)}
+        </select>
+      </div>
       <ResponsiveContainer width="100%" height={300}>
-        <LineChart data={mockData}>
+        <LineChart data={data}>
           <CartesianGrid strokeDasharray="3 3" stroke="#374151" />
           <XAxis dataKey="time" stroke="#9CA3AF" />
-          <YAxis stroke="#9CA3AF" />
+          <YAxis stroke="#9CA3AF" domain={[0, 100]} />
           <Tooltip />
-          <Line 
-            type="monotone" 
-            dataKey="cpu" 
-            stroke="#3B82F6" 
-            strokeWidth={3}
-            name="CPU %"
-          />
-          <Line 
-            type="monotone" 
-            dataKey="mem" 
-            stroke="#10B981" 
-            strokeWidth={3}
-            name="Memory %"
-          />
+          {lines.map(l => (
+            <Line
+              key={l.metric}
+              type="monotone"
+              dataKey={l.metric}
+              stroke={l.color}
+              strokeWidth={3}
+              dot={false}
+              name={l.name}
+            />
+          ))}
         </LineChart>
       </ResponsiveContainer>
     </div>
@@ -1,45 +1,38 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
 	"net/http"
-	"regexp"
+	"os"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+
+	"github.com/siem/internal/alerting"
+	"github.com/siem/internal/api"
+	"github.com/siem/internal/ingest"
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/pipeline"
+	"github.com/siem/internal/rules"
+	"github.com/siem/internal/sigma"
+	"github.com/siem/internal/storage"
+	"github.com/siem/internal/stream"
+	"github.com/siem/internal/syslog"
 )
 
-type NormalizedLog struct {
-	Timestamp time.Time `json:"ts"`
-	Host      string    `json:"host"`
-	Source    string    `json:"source"`
-	Message   string    `json:"msg"`
-	Level     string    `json:"level"`
-	EventType string    `json:"event_type"`
-	SrcIP     string    `json:"src_ip"`
-	DstPort   string    `json:"dst_port"`
-	User      string    `json:"user"`
-	Pid       int       `json:"pid"`
-	Raw       string    `json:"raw"`
-}
-
-type AlertV2 struct {
-	ID        uint          `json:"id"`
-	Rule      string        `json:"rule"`
-	Severity  string        `json:"severity"`
-	Score     float64       `json:"score"`
-	Message   string        `json:"message"`
-	Log       NormalizedLog `json:"log"`
-	Timestamp time.Time     `json:"alert_ts"`
-}
+type NormalizedLog = parser.NormalizedLog
+
+type AlertV2 = storage.Alert
 
 type LegacyLogEntry struct {
 	Timestamp string `json:"ts"`
@@ -47,213 +40,102 @@ type LegacyLogEntry struct {
 	Source    string `json:"source"`
 	Message   string `json:"msg"`
 	Level     string `json:"level"`
+	// Metrics are the values of an agent's metrics entry.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
 }
 
-type Storage struct {
-	normalizedLogs []NormalizedLog `json:"-"`
-	alertsV2       []AlertV2       `json:"-"`
-	mu             sync.RWMutex
+type LegacyAlert struct {
+	ID        uint   `json:"id"`
+	Type      string `json:"type"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Host      string `json:"host"`
+	Timestamp string `json:"ts"`
+	Status    string `json:"status"`
 }
 
 var (
-	storage    = &Storage{}
+	store      storage.Storage
+	ruleEngine *rules.RuleEngine
+	silences   *alerting.Silences
+	hub        = stream.NewHub(0)
 	upgrader   = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-	ruleEngine = NewRuleEngine()
 
-	// Регулярки для парсинга
-	sshFailedRe   = regexp.MustCompile(`Failed password|invalid user (.+?) from ([\d.]+)(?::(\d+))?`)
-	authSuccessRe = regexp.MustCompile(`Accepted password|Accepted publickey for (.+?) from ([\d.]+)`)
-	sudoRe        = regexp.MustCompile(`sudo: +(.+?) : ([\w-]+) ; TTY=pts/(\d+) ; PWD=`)
-	cpuMemRe      = regexp.MustCompile(`CPU:([\d.]+)% MEM:([\d.]+)%`)
+	ingestPipeline *pipeline.Pipeline[*ingestJob]
+	syslogServer   *syslog.Server // nil without syslog listeners
+	// logLocation is the zone of log timestamps that don't name one.
+	logLocation = time.UTC
 )
 
-type RuleEngine struct {
-	failedLogins map[string]int
-	lastReset    time.Time
-}
-
-func NewRuleEngine() *RuleEngine {
-	return &RuleEngine{
-		failedLogins: make(map[string]int),
-		lastReset:    time.Now(),
+func main() {
+	var err error
+	store, err = storage.Open(context.Background(), storage.Config{
+		Backend:     os.Getenv("STORAGE_BACKEND"),
+		DatabaseURL: os.Getenv("DATABASE_URL"),
+	})
+	if err != nil {
+		log.Fatal("Storage init failed: ", err)
 	}
-}
+	defer store.Close()
 
-func (r *RuleEngine) Check(log NormalizedLog) []AlertV2 {
-	r.resetSlidingWindow()
-	var alerts []AlertV2
-
-	switch log.EventType {
-	case "ssh_failed":
-		if alerts := r.checkSSHBruteforce(log); len(alerts) > 0 {
-			alerts = append(alerts, alerts...)
-		}
-	case "ssh_success":
-		if alerts := r.checkSuspiciousSuccess(log); len(alerts) > 0 {
-			alerts = append(alerts, alerts...)
+	ruleDefs, err := rules.Load(os.Getenv("RULES_DIR"))
+	if err != nil {
+		log.Fatal("Rules load failed: ", err)
+	}
+	if dir := os.Getenv("SIGMA_DIR"); dir != "" {
+		mapping, err := sigma.LoadMapping(os.Getenv("SIGMA_MAPPING"))
+		if err != nil {
+			log.Fatal("Sigma mapping load failed: ", err)
 		}
-	case "sudo":
-		if alerts := r.checkSudoAbuse(log); len(alerts) > 0 {
-			alerts = append(alerts, alerts...)
+		sigmaRules, skipped, err := sigma.Load(dir, mapping)
+		if err != nil {
+			log.Fatal("Sigma rules load failed: ", err)
 		}
-	case "metrics":
-		if alerts := r.checkResourceExhaustion(log); len(alerts) > 0 {
-			alerts = append(alerts, alerts...)
+		// A Sigma rule never replaces a built-in or custom rule.
+		ids := make(map[string]bool, len(ruleDefs))
+		for _, r := range ruleDefs {
+			ids[r.ID] = true
 		}
-	}
-
-	return alerts
-}
-
-func (r *RuleEngine) checkSSHBruteforce(log NormalizedLog) []AlertV2 {
-	if log.SrcIP == "" {
-		return nil
-	}
-	r.failedLogins[log.SrcIP]++
-
-	if count := r.failedLogins[log.SrcIP]; count >= 5 {
-		return []AlertV2{{
-			Rule:      "SSH_BRUTEFORCE",
-			Severity:  "HIGH",
-			Score:     float64(count) / 10.0,
-			Message:   fmt.Sprintf("SSH bruteforce %s: %d attempts", log.SrcIP, count),
-			Log:       log,
-			Timestamp: time.Now(),
-		}}
-	}
-	return nil
-}
-
-func (r *RuleEngine) checkSuspiciousSuccess(log NormalizedLog) []AlertV2 {
-	suspicious := []string{"root", "admin", "test", "ubuntu", "pi"}
-	for _, user := range suspicious {
-		if strings.Contains(strings.ToLower(log.User), user) {
-			return []AlertV2{{
-				Rule:      "SUSPICIOUS_LOGIN",
-				Severity:  "MEDIUM",
-				Score:     0.8,
-				Message:   fmt.Sprintf("Suspicious login %s from %s", log.User, log.SrcIP),
-				Log:       log,
-				Timestamp: time.Now(),
-			}}
+		sigmaRules = slices.DeleteFunc(sigmaRules, func(r rules.Rule) bool {
+			if ids[r.ID] {
+				skipped = append(skipped, fmt.Errorf("%s: rule id already used by a built-in or custom rule", r.ID))
+			}
+			return ids[r.ID]
+		})
+		for _, err := range skipped {
+			log.Printf("⚠️ Sigma rule skipped: %v", err)
 		}
+		log.Printf("📜 Converted %d Sigma rules (%d skipped)", len(sigmaRules), len(skipped))
+		ruleDefs = append(ruleDefs, sigmaRules...)
 	}
-	return nil
-}
-
-func (r *RuleEngine) checkSudoAbuse(log NormalizedLog) []AlertV2 {
-	if log.User != "root" && strings.Contains(log.Message, "/bin/sh") {
-		return []AlertV2{{
-			Rule:      "SUDO_PRIVESC",
-			Severity:  "HIGH",
-			Score:     0.95,
-			Message:   fmt.Sprintf("Sudo priv esc attempt by %s", log.User),
-			Log:       log,
-			Timestamp: time.Now(),
-		}}
-	}
-	return nil
-}
-
-func (r *RuleEngine) checkResourceExhaustion(log NormalizedLog) []AlertV2 {
-	if cpu, mem := extractMetrics(log.Message); cpu > 90 || mem > 90 {
-		return []AlertV2{{
-			Rule:      "RESOURCE_EXHAUSTION",
-			Severity:  "MEDIUM",
-			Score:     math.Max(cpu, mem) / 100.0,
-			Message:   fmt.Sprintf("High resources: CPU %.1f%% MEM %.1f%%", cpu, mem),
-			Log:       log,
-			Timestamp: time.Now(),
-		}}
-	}
-	return nil
-}
-
-func extractMetrics(msg string) (float64, float64) {
-	match := cpuMemRe.FindStringSubmatch(msg)
-	if len(match) == 3 {
-		cpu, _ := strconv.ParseFloat(match[1], 64)
-		mem, _ := strconv.ParseFloat(match[2], 64)
-		return cpu, mem
-	}
-	return 0, 0
-}
-
-func (r *RuleEngine) resetSlidingWindow() {
-	if time.Since(r.lastReset) > 5*time.Minute {
-		r.failedLogins = make(map[string]int)
-		r.lastReset = time.Now()
+	if ruleEngine, err = rules.NewRuleEngine(ruleDefs); err != nil {
+		log.Fatal("Rules init failed: ", err)
 	}
-}
+	log.Printf("📜 Loaded %d rules", len(ruleEngine.Rules()))
 
-func ParseLog(source, host, line string) NormalizedLog {
-	log := NormalizedLog{
-		Host:      host,
-		Source:    source,
-		Message:   strings.TrimSpace(line),
-		Raw:       line,
-		Timestamp: time.Now().UTC(),
-		Level:     parseLevel(line),
+	silences = alerting.NewSilences(store)
+	if err := silences.Reload(context.Background()); err != nil {
+		log.Fatal("Silences load failed: ", err)
 	}
+	go silences.Run(context.Background(), 30*time.Second)
+	go pruneMetrics(10 * time.Minute)
+	go sweepAnomalies(30 * time.Second)
 
-	// SSH Failed
-	if match := sshFailedRe.FindStringSubmatch(line); len(match) >= 3 {
-		log.EventType = "ssh_failed"
-		log.User = match[1]
-		log.SrcIP = match[2]
-		if len(match) > 3 && match[3] != "" {
-			port, _ := strconv.Atoi(match[3])
-			log.DstPort = strconv.Itoa(port)
+	if tz := os.Getenv("LOG_TIMEZONE"); tz != "" {
+		if logLocation, err = time.LoadLocation(tz); err != nil {
+			log.Fatal("LOG_TIMEZONE: ", err)
 		}
-		return log
 	}
-
-	// SSH Success
-	if match := authSuccessRe.FindStringSubmatch(line); len(match) >= 3 {
-		log.EventType = "ssh_success"
-		log.User = match[1]
-		log.SrcIP = match[2]
-		return log
+	ingestPipeline = newIngestPipeline(envInt("INGEST_WORKERS"), envInt("INGEST_QUEUE"))
+	if syslogServer, err = listenSyslog(); err != nil {
+		log.Fatal("Syslog listen failed: ", err)
 	}
 
-	// Sudo
-	if match := sudoRe.FindStringSubmatch(line); len(match) >= 4 {
-		log.EventType = "sudo"
-		log.User = match[1]
-		log.Pid, _ = strconv.Atoi(match[3])
-		return log
-	}
-
-	// Metrics
-	if cpuMemRe.MatchString(line) {
-		log.EventType = "metrics"
-		return log
-	}
-
-	log.EventType = "generic"
-	return log
-}
-
-func parseLevel(line string) string {
-	l := strings.ToLower(line)
-	switch {
-	case strings.Contains(l, "error"), strings.Contains(l, "failed"):
-		return "error"
-	case strings.Contains(l, "warn"):
-		return "warn"
-	case strings.Contains(l, "auth"), strings.Contains(l, "sudo"), strings.Contains(l, "password"):
-		return "security"
-	default:
-		return "info"
-	}
-}
-
-func main() {
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000", "http://localhost"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"*"},
 		AllowCredentials: true,
 	}))
@@ -265,11 +147,114 @@ func main() {
 	r.GET("/alerts/v2", alertsV2Handler)
 	r.GET("/health", healthHandler)
 	r.GET("/", dashboardHandler)
+	api.New(store, silences, hub).Register(r.Group("/api/v1"))
 
 	log.Println("🚀 SIEM Server v2.0: http://localhost:8080")
 	log.Fatal(r.Run(":8080"))
 }
 
+// envInt reads an optional integer setting; 0 when unset.
+func envInt(name string) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return n
+}
+
+// pruneMetrics drops expired metric samples and rollups every interval.
+func pruneMetrics(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := store.PruneMetrics(context.Background(), now); err != nil {
+			log.Printf("⚠️ Metrics prune failed: %v", err)
+		}
+	}
+}
+
+// sweepAnomalies closes the anomaly intervals that no later event closed
+// every interval and stores their alerts.
+func sweepAnomalies(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var alerts []AlertV2
+		for _, res := range ruleEngine.Sweep(now) {
+			if alert, ok := newAlert(res.Result, res.Log); ok {
+				alerts = append(alerts, alert)
+			}
+		}
+		if len(alerts) == 0 {
+			continue
+		}
+		if err := store.SaveAlerts(context.Background(), alerts); err != nil {
+			log.Printf("⚠️ Anomaly alerts lost: %v", err)
+			continue
+		}
+		hub.Publish(nil, alerts)
+	}
+}
+
+// listenSyslog starts the syslog listeners set in SYSLOG_UDP, SYSLOG_TCP
+// and SYSLOG_TLS, if any. TLS needs SYSLOG_TLS_CERT and SYSLOG_TLS_KEY;
+// with SYSLOG_TLS_CA, senders must have a client certificate it signed.
+func listenSyslog() (*syslog.Server, error) {
+	cfg := syslog.Config{
+		UDP:    os.Getenv("SYSLOG_UDP"),
+		TCP:    os.Getenv("SYSLOG_TCP"),
+		TLS:    os.Getenv("SYSLOG_TLS"),
+		Handle: syslogBatch,
+	}
+	if cfg.UDP == "" && cfg.TCP == "" && cfg.TLS == "" {
+		return nil, nil
+	}
+	if cfg.TLS != "" {
+		cert, err := tls.LoadX509KeyPair(os.Getenv("SYSLOG_TLS_CERT"), os.Getenv("SYSLOG_TLS_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		cfg.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		if ca := os.Getenv("SYSLOG_TLS_CA"); ca != "" {
+			pem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("tls: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("tls: no certificates in %s", ca)
+			}
+			cfg.TLSConfig.ClientCAs = pool
+			cfg.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	srv, err := syslog.Listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📡 Syslog listening: udp=%q tcp=%q tls=%q", cfg.UDP, cfg.TCP, cfg.TLS)
+	return srv, nil
+}
+
+// syslogBatch queues the messages of one syslog sender for ingestion.
+// Their host is the one in the syslog header, else the sender address.
+func syslogBatch(msgs []syslog.Message) {
+	job := &ingestJob{fromSyslog: true, reply: func([]byte) {}}
+	for _, m := range msgs {
+		job.entries = append(job.entries, LegacyLogEntry{
+			Timestamp: m.Received.Format(time.RFC3339Nano),
+			Host:      m.Host,
+			Source:    "syslog/" + m.Transport,
+			Message:   m.Line,
+		})
+	}
+	ingestPipeline.Submit(context.Background(), "syslog/"+msgs[0].Host, job)
+}
+
 func wsHandler(c *gin.Context) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -280,84 +265,382 @@ func wsHandler(c *gin.Context) {
 
 	log.Printf("🟢 Agent %s connected", c.RemoteIP())
 
+	ac := &agentConn{conn: conn, out: make(chan []byte, 64), done: make(chan struct{})}
+	defer close(ac.done)
+	go ac.writeLoop()
+
+	// Reading stops while the agent's pipeline shard is full, so a flood
+	// backs up into the agent instead of into memory.
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
-		go handleLogs(conn, msg)
+		job := decodeBatch(msg, ac.send)
+		if job == nil {
+			continue
+		}
+		key := job.batch.AgentID
+		if key == "" {
+			key = job.batch.Host
+		}
+		if err := ingestPipeline.Submit(context.Background(), key, job); err != nil {
+			break
+		}
+	}
+}
+
+// agentWriteTimeout bounds a write of a reply to an agent.
+const agentWriteTimeout = 10 * time.Second
+
+// agentConn serializes the writes to an agent connection, which
+// gorilla/websocket allows from one goroutine at a time. Replies come
+// from pipeline workers.
+type agentConn struct {
+	conn *websocket.Conn
+	out  chan []byte
+	done chan struct{} // closed when the reader is gone
+}
+
+// send queues a reply without ever blocking the pipeline worker. When the
+// agent doesn't read its replies and the queue is full, the reply is
+// dropped and the connection closed; the agent resends what is unacked
+// after reconnecting.
+func (a *agentConn) send(msg []byte) {
+	select {
+	case <-a.done:
+		return
+	default:
+	}
+	select {
+	case a.out <- msg:
+	default:
+		log.Printf("⚠️ Agent %s doesn't read replies, closing", a.conn.RemoteAddr())
+		a.conn.Close()
+	}
+}
+
+func (a *agentConn) writeLoop() {
+	for {
+		select {
+		case msg := <-a.out:
+			a.conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+			if err := a.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("WS write error: %v", err)
+				a.conn.Close()
+				return
+			}
+		case <-a.done:
+			return
+		}
 	}
 }
 
-func handleLogs(conn *websocket.Conn, data []byte) {
-	var batch struct {
-		Type  string           `json:"type"`
-		Host  string           `json:"host"`
-		Batch []LegacyLogEntry `json:"batch"`
+// ingestJob is one agent batch in the ingest pipeline: decode (on the
+// connection's reader), parse, enrich, rules, store. Legacy batches
+// (version 0) are answered with a plain "OK". Syslog messages come as
+// legacy batches per sender.
+type ingestJob struct {
+	batch   ingest.Batch
+	entries []LegacyLogEntry
+	logs    []NormalizedLog
+	alerts  []AlertV2
+	// seen is set for batches stored before; they are only acked.
+	seen, duplicate bool
+	// tracked is set while the batch is in inflight; overtook for a
+	// redelivery that reached the rules while its original was in flight.
+	tracked, overtook bool
+	fromSyslog        bool
+	reply             func([]byte)
+}
+
+// inflight holds the agent batches between the rules and the end of the
+// store stage. BatchSeen only knows stored batches, so a redelivery that
+// reaches the rules while its original is queued for the store would
+// count towards thresholds twice without it.
+var inflight = struct {
+	sync.Mutex
+	batches map[inflightBatch]bool
+}{batches: make(map[inflightBatch]bool)}
+
+type inflightBatch struct {
+	agentID string
+	seq     uint64
+}
+
+// track adds b to inflight unless it is there already.
+func track(b *ingest.Batch) bool {
+	inflight.Lock()
+	defer inflight.Unlock()
+	k := inflightBatch{b.AgentID, b.Seq}
+	if inflight.batches[k] {
+		return false
 	}
+	inflight.batches[k] = true
+	return true
+}
+
+func untrack(b *ingest.Batch) {
+	inflight.Lock()
+	defer inflight.Unlock()
+	delete(inflight.batches, inflightBatch{b.AgentID, b.Seq})
+}
+
+func newIngestPipeline(workers, queue int) *pipeline.Pipeline[*ingestJob] {
+	return pipeline.New(pipeline.Config[*ingestJob]{
+		Workers: workers,
+		Queue:   queue,
+		Stages: []pipeline.Stage[*ingestJob]{
+			{Name: "parse", Run: parseStage},
+			{Name: "enrich", Run: enrichStage},
+			{Name: "rules", Run: rulesStage},
+			{Name: "store", Run: storeStage},
+		},
+		Done: replyBatch,
+	})
+}
 
-	if err := json.Unmarshal(data, &batch); err != nil {
+// decodeBatch returns nil for frames that are answered right away.
+func decodeBatch(data []byte, reply func([]byte)) *ingestJob {
+	job := &ingestJob{reply: reply}
+	if err := json.Unmarshal(data, &job.batch); err != nil {
 		log.Printf("JSON parse error: %v", err)
-		return
+		return nil
+	}
+	batch := &job.batch
+
+	var nack ingest.Reply
+	switch {
+	case batch.Version == 0:
+		if err := json.Unmarshal(batch.Batch, &job.entries); err != nil {
+			log.Printf("JSON parse error: %v", err)
+			return nil
+		}
+		return job
+	case batch.Version != ingest.Version:
+		nack = ingest.Nack(batch.Seq, fmt.Errorf("unsupported protocol version %d", batch.Version), false)
+	default:
+		// A batch that fails verification fails it again when resent, as
+		// it comes from the same spool file, so it is rejected for good.
+		err := batch.Verify()
+		if err == nil {
+			if err = json.Unmarshal(batch.Batch, &job.entries); err == nil {
+				return job
+			}
+			err = fmt.Errorf("decode batch: %w", err)
+		}
+		nack = ingest.Nack(batch.Seq, err, false)
+	}
+	log.Printf("⚠️ Nack %s#%d: %s", batch.AgentID, batch.Seq, nack.Error)
+	out, _ := json.Marshal(nack)
+	reply(out)
+	return nil
+}
+
+func parseStage(job *ingestJob) error {
+	received := time.Now().UTC()
+	job.logs = make([]NormalizedLog, 0, len(job.entries))
+	for _, e := range job.entries {
+		l := parser.ParseLog(e.Source, e.Message)
+		if len(e.Metrics) > 0 {
+			parser.SetMetrics(&l, e.Metrics)
+		}
+		l.Host = job.batch.Host
+		l.IngestTime = received
+		l.Timestamp = eventTime(e, received)
+		job.logs = append(job.logs, l)
 	}
+	return nil
+}
 
-	storage.mu.Lock()
-	for i := range batch.Batch {
-		// ✅ НОРМАЛИЗАЦИЯ ЛОГОВ
-		normLog := ParseLog(batch.Batch[i].Source, batch.Host, batch.Batch[i].Message)
+// maxFutureSkew is how far after the server received it an event may be
+// dated. Later times are wrong clocks or forged, e.g. over UDP syslog, and
+// would push threshold windows and anomaly intervals ahead of all other
+// events.
+const maxFutureSkew = 5 * time.Minute
+
+// eventTime returns the time in the line, else the time the agent read
+// it, else the time the server received it. Times more than maxFutureSkew
+// after received are ignored.
+func eventTime(e LegacyLogEntry, received time.Time) time.Time {
+	limit := received.Add(maxFutureSkew)
+	ref := received
+	if t, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil && !t.After(limit) {
+		ref = t.UTC()
+	}
+	if t, ok := parser.EventTime(e.Message, ref, logLocation); ok && !t.After(limit) {
+		return t
+	}
+	return ref
+}
+
+func enrichStage(job *ingestJob) error {
+	for i := range job.logs {
+		l := &job.logs[i]
+		switch {
+		case l.Host != "":
+		case job.fromSyslog && l.Hostname != "":
+			// Relays forward the messages of other hosts.
+			l.Host = l.Hostname
+		default:
+			l.Host = job.entries[i].Host
+		}
+	}
+	return nil
+}
 
-		storage.normalizedLogs = append(storage.normalizedLogs, normLog)
-		if len(storage.normalizedLogs) > 10000 {
-			storage.normalizedLogs = storage.normalizedLogs[1000:]
+func rulesStage(job *ingestJob) error {
+	if job.batch.Version != 0 {
+		// Checked before the rules run so a redelivered batch does not
+		// count towards thresholds twice, also while its original is
+		// still in flight.
+		seen, err := store.BatchSeen(context.Background(), job.batch.AgentID, job.batch.Seq)
+		if err != nil {
+			return err
+		}
+		if job.seen = seen; seen {
+			return nil
+		}
+		if job.tracked = track(&job.batch); !job.tracked {
+			job.overtook = true
+			return nil
 		}
+	}
 
-		// ✅ ПРОВЕРКА ПРАВИЛ v2.0
-		alerts := ruleEngine.Check(normLog)
-		for _, alert := range alerts {
-			storage.alertsV2 = append(storage.alertsV2, alert)
-			if len(storage.alertsV2) > 1000 {
-				storage.alertsV2 = storage.alertsV2[100:]
+	for _, normLog := range job.logs {
+		for _, res := range ruleEngine.Check(normLog) {
+			if alert, ok := newAlert(res, normLog); ok {
+				job.alerts = append(job.alerts, alert)
 			}
-			log.Printf("🔴 ALERT [%s] %.2f: %s", alert.Severity, alert.Score, alert.Message)
 		}
 	}
-	storage.mu.Unlock()
+	return nil
+}
 
-	conn.WriteMessage(websocket.TextMessage, []byte("OK"))
-	log.Printf("💾 Saved %d normalized logs from %s", len(batch.Batch), batch.Host)
+// newAlert builds the alert of a rule result for normLog; false if a
+// silence mutes it.
+func newAlert(res rules.RuleResult, normLog parser.NormalizedLog) (AlertV2, bool) {
+	alert := AlertV2{
+		Rule:      res.Type,
+		Severity:  res.Severity,
+		Score:     res.Score,
+		Message:   res.Message,
+		Mitre:     res.Mitre,
+		Log:       normLog,
+		Logs:      res.Logs,
+		Timestamp: time.Now(),
+
+		DedupKey:    res.DedupKey,
+		DedupWindow: res.DedupWindow,
+	}
+	if id, muted := silences.Muted(alert); muted {
+		log.Printf("🔇 Alert %s muted by silence %d", alert.Rule, id)
+		return AlertV2{}, false
+	}
+	log.Printf("🔴 ALERT [%s] %.2f: %s", alert.Severity, alert.Score, alert.Message)
+	return alert, true
 }
 
-func normalizedLogsHandler(c *gin.Context) {
-	storage.mu.RLock()
-	logs := make([]NormalizedLog, len(storage.normalizedLogs))
-	copy(logs, storage.normalizedLogs)
-	storage.mu.RUnlock()
+func storeStage(job *ingestJob) error {
+	ctx := context.Background()
+	batch := &job.batch
+	switch {
+	case job.seen:
+		return nil
+	case job.overtook:
+		// Batches of one agent are stored in order, so the original has
+		// been by now, unless that failed.
+		seen, err := store.BatchSeen(ctx, batch.AgentID, batch.Seq)
+		if err != nil {
+			return err
+		}
+		if !seen {
+			return fmt.Errorf("batch redelivered while its original failed to store")
+		}
+		job.seen = true
+		return nil
+	case batch.Version == 0:
+		if err := store.SaveLogs(ctx, job.logs); err != nil {
+			return err
+		}
+		if err := store.SaveAlerts(ctx, job.alerts); err != nil {
+			return err
+		}
+		from := batch.Host
+		if job.fromSyslog {
+			from = "syslog " + job.entries[0].Host
+		}
+		log.Printf("💾 Saved %d normalized logs from %s", len(job.logs), from)
+	default:
+		duplicate, err := store.SaveBatch(ctx, storage.Batch{
+			AgentID: batch.AgentID,
+			Seq:     batch.Seq,
+			Logs:    job.logs,
+			Alerts:  job.alerts,
+		})
+		if err != nil {
+			return err
+		}
+		if job.duplicate = duplicate; duplicate {
+			return nil
+		}
+		log.Printf("💾 Saved %d normalized logs from %s (%s#%d)", len(job.logs), batch.Host, batch.AgentID, batch.Seq)
+	}
+	hub.Publish(job.logs, job.alerts)
+	return nil
+}
 
-	logsToSend := logs
-	if len(logsToSend) > 100 {
-		logsToSend = logsToSend[len(logsToSend)-100:]
+func replyBatch(job *ingestJob, err error) {
+	batch := &job.batch
+	if job.tracked {
+		untrack(batch)
 	}
-	c.JSON(200, gin.H{"logs": logsToSend})
+	if batch.Version == 0 {
+		if err != nil {
+			log.Printf("Store error: %v", err)
+			return
+		}
+		job.reply([]byte("OK"))
+		return
+	}
+
+	reply := ingest.Ack(batch.Seq, job.seen || job.duplicate)
+	if err != nil {
+		reply = ingest.Nack(batch.Seq, err, true)
+		log.Printf("⚠️ Nack %s#%d: %s", batch.AgentID, batch.Seq, reply.Error)
+	}
+	out, _ := json.Marshal(reply)
+	job.reply(out)
 }
 
-func alertsV2Handler(c *gin.Context) {
-	storage.mu.RLock()
-	alerts := make([]AlertV2, len(storage.alertsV2))
-	copy(alerts, storage.alertsV2)
-	storage.mu.RUnlock()
+func normalizedLogsHandler(c *gin.Context) {
+	logs, err := store.RecentLogs(c.Request.Context(), 100)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"logs": logs})
+}
 
-	alertsToSend := alerts
-	if len(alertsToSend) > 50 {
-		alertsToSend = alertsToSend[len(alertsToSend)-50:]
+func alertsV2Handler(c *gin.Context) {
+	alerts, err := store.RecentAlerts(c.Request.Context(), 50)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
-	c.JSON(200, gin.H{"alerts": alertsToSend})
+	c.JSON(200, gin.H{"alerts": alerts})
 }
 
 func logsHandler(c *gin.Context) {
 	// Legacy endpoint
-	storage.mu.RLock()
+	logs, err := store.RecentLogs(c.Request.Context(), 100)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
 	var legacyLogs []LegacyLogEntry
-	for _, log := range storage.normalizedLogs {
+	for _, log := range logs {
 		legacyLogs = append(legacyLogs, LegacyLogEntry{
 			Timestamp: log.Timestamp.Format(time.RFC3339),
 			Host:      log.Host,
@@ -366,25 +649,55 @@ func logsHandler(c *gin.Context) {
 			Level:     log.Level,
 		})
 	}
-	storage.mu.RUnlock()
-
-	if len(legacyLogs) > 100 {
-		legacyLogs = legacyLogs[len(legacyLogs)-100:]
-	}
 	c.JSON(200, gin.H{"logs": legacyLogs})
 }
 
 func alertsHandler(c *gin.Context) {
-	// Legacy alerts
-	c.JSON(200, gin.H{"alerts": []interface{}{}})
+	// Legacy endpoint: active alerts, newest first
+	alerts, err := store.ListAlerts(c.Request.Context(), storage.AlertFilter{
+		Status: storage.ActiveStatuses,
+		Limit:  100,
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	legacyAlerts := []LegacyAlert{}
+	for _, a := range alerts {
+		legacyAlerts = append(legacyAlerts, LegacyAlert{
+			ID:        a.ID,
+			Type:      a.Rule,
+			Severity:  a.Severity,
+			Message:   a.Message,
+			Host:      a.Log.Host,
+			Timestamp: a.Timestamp.Format(time.RFC3339),
+			Status:    a.Status,
+		})
+	}
+	c.JSON(200, gin.H{"alerts": legacyAlerts})
 }
 
 func healthHandler(c *gin.Context) {
+	st, err := store.Stats(c.Request.Context())
+	if err != nil {
+		c.JSON(503, gin.H{"status": "unhealthy", "error": err.Error()})
+		return
+	}
+
 	stats := gin.H{
 		"status":             "healthy",
-		"normalized_logs":    len(storage.normalizedLogs),
-		"alerts_v2":          len(storage.alertsV2),
-		"active_bruteforces": len(ruleEngine.failedLogins),
+		"normalized_logs":    st.Logs,
+		"alerts_v2":          st.Alerts,
+		"rules":              len(ruleEngine.Rules()),
+		"active_bruteforces": ruleEngine.ActiveGroups(),
+		"silenced_alerts":    silences.MutedCount(),
+		"stream_clients":     hub.Clients(),
+		"stream_dropped":     hub.Dropped(),
+		"ingest_queued":      ingestPipeline.Queued(),
+	}
+	if syslogServer != nil {
+		stats["syslog_received"] = syslogServer.Received()
 	}
 	c.JSON(200, stats)
 }
@@ -407,19 +720,37 @@ func dashboardHandler(c *gin.Context) {
 <pre id="alerts" class="bg-red-900/50 p-4 h-96 overflow-auto border rounded-lg text-xs"></pre>
 </div></div>
 <script>
-async function refresh() {
+const max=100;
+let logs=[],alerts=[];
+function render() {
+	document.getElementById('logs').textContent=JSON.stringify(logs,null,2);
+	document.getElementById('alerts').textContent=JSON.stringify(alerts,null,2);
+	document.getElementById('logCount').textContent=logs.length;
+	document.getElementById('alertCount').textContent=alerts.length;
+}
+async function load() {
+	try {
+		logs=((await(await fetch('/logs/normalized')).json()).logs||[]).reverse();
+		alerts=((await(await fetch('/alerts/v2')).json()).alerts||[]).reverse();
+		render();
+	} catch(e) {console.error(e);}
+}
+async function health() {
 	try {
-		const logs=await(await fetch('/logs/normalized')).json();
-		const alerts=await(await fetch('/alerts/v2')).json();
-		const health=await(await fetch('/health')).json();
-		document.getElementById('logs').textContent=JSON.stringify(logs.logs||[],null,2);
-		document.getElementById('alerts').textContent=JSON.stringify(alerts.alerts||[],null,2);
-		document.getElementById('logCount').textContent=logs.logs?.length||0;
-		document.getElementById('alertCount').textContent=alerts.alerts?.length||0;
-		document.title='SIEM v2.0 | '+health.normalized_logs+' logs | '+health.alerts_v2+' alerts';
+		const h=await(await fetch('/health')).json();
+		document.title='SIEM v2.0 | '+h.normalized_logs+' logs | '+h.alerts_v2+' alerts';
 	} catch(e) {console.error(e);}
 }
-refresh();setInterval(refetch,2000);
+const es=new EventSource('/api/v1/stream');
+es.onopen=load;
+es.addEventListener('log',e=>{logs.unshift(JSON.parse(e.data).log);logs.length=Math.min(logs.length,max);render();});
+es.addEventListener('alert',e=>{
+	const a=JSON.parse(e.data).alert;
+	alerts=[a,...alerts.filter(x=>x.id!==a.id)].slice(0,max);
+	render();
+});
+es.addEventListener('dropped',load);
+health();setInterval(health,10000);
 </script></body></html>`
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	c.Data(200, "text/html; charset=utf-8", []byte(html))
@@ -0,0 +1,165 @@
+// Package alerting holds the state that decides whether rule results
+// become alerts.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/storage"
+)
+
+// Silences caches the active silences of the store. Other replicas'
+// changes are picked up by Run; local changes should call Reload.
+type Silences struct {
+	store storage.Storage
+	muted atomic.Int64
+
+	mu     sync.RWMutex
+	active []compiledSilence
+}
+
+type compiledSilence struct {
+	storage.Silence
+	matchers []func(storage.Alert) bool
+}
+
+func NewSilences(store storage.Storage) *Silences {
+	return &Silences{store: store}
+}
+
+// Validate checks a silence before it is stored.
+func Validate(s storage.Silence) error {
+	if len(s.Matchers) == 0 {
+		return fmt.Errorf("silence needs at least one matcher")
+	}
+	if !s.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("silence must expire in the future")
+	}
+	_, err := compile(s)
+	return err
+}
+
+func compile(s storage.Silence) (compiledSilence, error) {
+	c := compiledSilence{Silence: s}
+	for _, m := range s.Matchers {
+		get, err := field(m.Field)
+		if err != nil {
+			return c, err
+		}
+
+		value := m.Value
+		var match func(storage.Alert) bool
+		switch m.Op {
+		case "", "=":
+			match = func(a storage.Alert) bool { return get(a) == value }
+		case "!=":
+			match = func(a storage.Alert) bool { return get(a) != value }
+		case "=~":
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return c, fmt.Errorf("matcher %s: %w", m.Field, err)
+			}
+			match = func(a storage.Alert) bool { return re.MatchString(get(a)) }
+		default:
+			return c, fmt.Errorf("matcher %s: unknown op %q", m.Field, m.Op)
+		}
+		c.matchers = append(c.matchers, match)
+	}
+	return c, nil
+}
+
+// field returns an accessor for the alert's rule or severity, or a field
+// of its log.
+func field(name string) (func(storage.Alert) string, error) {
+	switch name {
+	case "rule":
+		return func(a storage.Alert) string { return a.Rule }, nil
+	case "severity":
+		return func(a storage.Alert) string { return strings.ToUpper(a.Severity) }, nil
+	}
+	if !parser.KnownField(name) {
+		return nil, fmt.Errorf("unknown matcher field %q", name)
+	}
+	return func(a storage.Alert) string {
+		v, _ := a.Log.Field(name)
+		return v
+	}, nil
+}
+
+// Reload replaces the cache with the store's active silences.
+func (s *Silences) Reload(ctx context.Context) error {
+	list, err := s.store.ListSilences(ctx, false)
+	if err != nil {
+		return err
+	}
+	active := make([]compiledSilence, 0, len(list))
+	for _, sil := range list {
+		c, err := compile(sil)
+		if err != nil {
+			log.Printf("⚠️ Silence %d ignored: %v", sil.ID, err)
+			continue
+		}
+		active = append(active, c)
+	}
+
+	s.mu.Lock()
+	s.active = active
+	s.mu.Unlock()
+	return nil
+}
+
+// Run reloads every interval until ctx is done.
+func (s *Silences) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.Reload(ctx); err != nil {
+				log.Printf("Silences reload error: %v", err)
+			}
+		}
+	}
+}
+
+// Muted returns the ID of an active silence matching a.
+func (s *Silences) Muted(a storage.Alert) (uint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, sil := range s.active {
+		if !sil.ExpiresAt.After(now) {
+			continue
+		}
+		if sil.matches(a) {
+			s.muted.Add(1)
+			return sil.ID, true
+		}
+	}
+	return 0, false
+}
+
+// MutedCount returns how many alerts were muted since start.
+func (s *Silences) MutedCount() int64 {
+	return s.muted.Load()
+}
+
+func (c *compiledSilence) matches(a storage.Alert) bool {
+	for _, m := range c.matchers {
+		if !m(a) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/storage"
+)
+
+func TestSilencesMuted(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory(0, 0)
+	expires := time.Now().Add(time.Hour)
+	for _, s := range []storage.Silence{
+		{Matchers: []storage.Matcher{{Field: "rule", Value: "SSH_BRUTEFORCE"}, {Field: "src_ip", Op: "=~", Value: `10\..*`}}, ExpiresAt: expires},
+		{Matchers: []storage.Matcher{{Field: "severity", Value: "LOW"}, {Field: "host", Op: "!=", Value: "db1"}}, ExpiresAt: expires},
+		{Matchers: []storage.Matcher{{Field: "rule", Value: "WEB_SQLI"}}, ExpiresAt: time.Now().Add(-time.Minute)},
+	} {
+		if _, err := store.CreateSilence(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewSilences(store)
+	if err := s.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		alert storage.Alert
+		want  bool
+	}{
+		{"rule and regex", storage.Alert{Rule: "SSH_BRUTEFORCE", Log: parser.NormalizedLog{SrcIP: "10.0.0.7"}}, true},
+		{"regex is anchored", storage.Alert{Rule: "SSH_BRUTEFORCE", Log: parser.NormalizedLog{SrcIP: "110.0.0.7"}}, false},
+		{"severity in any case", storage.Alert{Rule: "X", Severity: "low", Log: parser.NormalizedLog{Host: "web1"}}, true},
+		{"not equal", storage.Alert{Rule: "X", Severity: "LOW", Log: parser.NormalizedLog{Host: "db1"}}, false},
+		{"expired", storage.Alert{Rule: "WEB_SQLI"}, false},
+	}
+	for _, tt := range tests {
+		if _, got := s.Muted(tt.alert); got != tt.want {
+			t.Errorf("%s: muted = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if n := s.MutedCount(); n != 2 {
+		t.Errorf("MutedCount = %d, want 2", n)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	for name, s := range map[string]storage.Silence{
+		"no matchers":   {ExpiresAt: expires},
+		"expired":       {Matchers: []storage.Matcher{{Field: "rule", Value: "X"}}, ExpiresAt: time.Now()},
+		"unknown field": {Matchers: []storage.Matcher{{Field: "nope", Value: "X"}}, ExpiresAt: expires},
+		"unknown op":    {Matchers: []storage.Matcher{{Field: "rule", Op: "~", Value: "X"}}, ExpiresAt: expires},
+		"bad regex":     {Matchers: []storage.Matcher{{Field: "rule", Op: "=~", Value: "("}}, ExpiresAt: expires},
+	} {
+		if err := Validate(s); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
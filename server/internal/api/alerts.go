@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/siem/internal/storage"
+)
+
+// listAlerts returns alerts newest first. Filters: status (comma
+// separated, "active" for new, acknowledged and in-progress), severity,
+// rule, host, assignee, query (see package query), from, to (RFC 3339)
+// and limit.
+func (a *API) listAlerts(c *gin.Context) {
+	f, err := alertFilter(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	alerts, err := a.store.ListAlerts(c.Request.Context(), f)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if alerts == nil {
+		alerts = []storage.Alert{}
+	}
+	c.JSON(200, gin.H{"alerts": alerts})
+}
+
+func alertFilter(c *gin.Context) (storage.AlertFilter, error) {
+	f := storage.AlertFilter{
+		Rule:     c.Query("rule"),
+		Host:     c.Query("host"),
+		Assignee: c.Query("assignee"),
+	}
+	for _, s := range list(c, "status") {
+		switch {
+		case s == "active":
+			f.Status = append(f.Status, storage.ActiveStatuses...)
+		case storage.ValidStatus(s):
+			f.Status = append(f.Status, s)
+		default:
+			return f, invalid(fmt.Errorf("invalid status %q", s))
+		}
+	}
+	for _, s := range list(c, "severity") {
+		f.Severity = append(f.Severity, strings.ToUpper(s))
+	}
+
+	var err error
+	if f.Query, err = queryParam(c, storage.AlertSchema); err != nil {
+		return f, err
+	}
+	if f.From, err = timeParam(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = timeParam(c, "to"); err != nil {
+		return f, err
+	}
+	if f.Limit, err = intParam(c, "limit"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// getAlert returns one alert with its history.
+func (a *API) getAlert(c *gin.Context) {
+	id, err := alertID(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	alert, err := a.store.Alert(c.Request.Context(), id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(200, alert)
+}
+
+type alertUpdate struct {
+	Actor    string  `json:"actor"`
+	Status   string  `json:"status"`
+	Assignee *string `json:"assignee"` // "" unassigns
+	Comment  string  `json:"comment"`
+}
+
+// updateAlert changes status and assignee and optionally adds a comment.
+func (a *API) updateAlert(c *gin.Context) {
+	var req alertUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	a.update(c, storage.AlertUpdate{
+		Actor:    req.Actor,
+		Status:   req.Status,
+		Assignee: req.Assignee,
+		Comment:  req.Comment,
+	})
+}
+
+type comment struct {
+	Actor string `json:"actor"`
+	Text  string `json:"text"`
+}
+
+func (a *API) addComment(c *gin.Context) {
+	var req comment
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		fail(c, invalid(errors.New("empty comment")))
+		return
+	}
+	a.update(c, storage.AlertUpdate{Actor: req.Actor, Comment: req.Text})
+}
+
+func (a *API) update(c *gin.Context, u storage.AlertUpdate) {
+	id, err := alertID(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err := u.Validate(); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	alert, err := a.store.UpdateAlert(c.Request.Context(), id, u)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(200, alert)
+}
+
+func alertID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, invalid(fmt.Errorf("invalid alert id %q", c.Param("id")))
+	}
+	return uint(id), nil
+}
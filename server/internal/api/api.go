@@ -0,0 +1,128 @@
+// Package api serves the REST endpoints under /api/v1.
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/siem/internal/alerting"
+	"github.com/siem/internal/query"
+	"github.com/siem/internal/storage"
+	"github.com/siem/internal/stream"
+)
+
+type API struct {
+	store    storage.Storage
+	silences *alerting.Silences
+	hub      *stream.Hub
+}
+
+func New(store storage.Storage, silences *alerting.Silences, hub *stream.Hub) *API {
+	return &API{store: store, silences: silences, hub: hub}
+}
+
+func (a *API) Register(r gin.IRouter) {
+	r.GET("/alerts", a.listAlerts)
+	r.GET("/alerts/:id", a.getAlert)
+	r.PATCH("/alerts/:id", a.updateAlert)
+	r.POST("/alerts/:id/comments", a.addComment)
+
+	r.GET("/logs", a.searchLogs)
+	r.GET("/logs/histogram", a.logHistogram)
+	r.GET("/logs/terms", a.topTerms)
+	r.GET("/logs/cardinality", a.cardinality)
+
+	r.GET("/metrics", a.listMetrics)
+	r.GET("/metrics/range", a.metricRange)
+
+	r.GET("/stream", a.stream)
+
+	r.GET("/silences", a.listSilences)
+	r.POST("/silences", a.createSilence)
+	r.DELETE("/silences/:id", a.expireSilence)
+}
+
+// fail writes err as a JSON error with a status matching its kind.
+func fail(c *gin.Context, err error) {
+	status := 500
+	var bad badRequest
+	switch {
+	case errors.As(err, &bad), errors.Is(err, storage.ErrInvalidCursor), errors.Is(err, storage.ErrTooManyBuckets):
+		status = 400
+	case errors.Is(err, storage.ErrNotFound):
+		status = 404
+	}
+	body := gin.H{"error": err.Error()}
+	var syntax *query.SyntaxError
+	if errors.As(err, &syntax) {
+		body["offset"] = syntax.Offset
+	}
+	c.JSON(status, body)
+}
+
+type badRequest struct{ error }
+
+func (e badRequest) Unwrap() error { return e.error }
+
+func invalid(err error) error {
+	return badRequest{err}
+}
+
+// list returns a comma separated query parameter, which may also be
+// repeated.
+func list(c *gin.Context, name string) []string {
+	var out []string
+	for _, v := range c.QueryArray(name) {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// queryParam parses the query parameter "query", which is nil if unset.
+func queryParam(c *gin.Context, schema query.Schema) (*query.Query, error) {
+	q, err := parseQuery(c.Query("query"), schema)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	return q, nil
+}
+
+// parseQuery returns nil for a blank query.
+func parseQuery(s string, schema query.Schema) (*query.Query, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	return query.Parse(s, schema)
+}
+
+func timeParam(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, invalid(errors.New(name + ": expected RFC 3339 time"))
+	}
+	return t, nil
+}
+
+func intParam(c *gin.Context, name string) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, invalid(errors.New(name + ": expected a non-negative integer"))
+	}
+	return n, nil
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/storage"
+)
+
+// searchLogs returns one page of logs. Filters: host, source, level,
+// event_type, src_ip (address or CIDR), user, q (text in the message),
+// query (see package query), from, to (RFC 3339); order=asc|desc (by
+// timestamp, default desc), limit and cursor (next_cursor of the previous
+// page).
+func (a *API) searchLogs(c *gin.Context) {
+	q, err := logQuery(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	page, err := a.store.SearchLogs(c.Request.Context(), q)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(200, page)
+}
+
+func logQuery(c *gin.Context) (storage.LogQuery, error) {
+	q := storage.LogQuery{
+		Host:      c.Query("host"),
+		Source:    c.Query("source"),
+		Level:     c.Query("level"),
+		EventType: c.Query("event_type"),
+		SrcIP:     c.Query("src_ip"),
+		User:      c.Query("user"),
+		Text:      c.Query("q"),
+		Cursor:    c.Query("cursor"),
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		q.Asc = true
+	case "desc":
+	default:
+		return q, invalid(fmt.Errorf("order: expected asc or desc"))
+	}
+
+	var err error
+	if q.Query, err = queryParam(c, storage.LogSchema); err != nil {
+		return q, err
+	}
+	if q.From, err = timeParam(c, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = timeParam(c, "to"); err != nil {
+		return q, err
+	}
+	if q.Limit, err = intParam(c, "limit"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// logHistogram counts logs per interval (a Go duration, default 1m). It
+// takes the filters of searchLogs.
+func (a *API) logHistogram(c *gin.Context) {
+	q, err := logQuery(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	interval, err := time.ParseDuration(c.DefaultQuery("interval", "1m"))
+	if err != nil || interval < time.Second {
+		fail(c, invalid(fmt.Errorf("interval: expected a duration of at least 1s")))
+		return
+	}
+	buckets, err := a.store.LogHistogram(c.Request.Context(), q, interval)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"interval": interval.String(), "buckets": buckets})
+}
+
+// topTerms returns the most frequent values of field, at most size
+// (default 10). It takes the filters of searchLogs.
+func (a *API) topTerms(c *gin.Context) {
+	q, field, size, err := aggregation(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	terms, err := a.store.TopTerms(c.Request.Context(), q, field, size)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"field": field, "terms": terms})
+}
+
+// cardinality counts the distinct values of field, per value of by when
+// set. It takes the filters of searchLogs.
+func (a *API) cardinality(c *gin.Context) {
+	q, field, size, err := aggregation(c)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	by := c.Query("by")
+	if by != "" && !parser.KnownField(by) {
+		fail(c, invalid(fmt.Errorf("by: unknown field %q", by)))
+		return
+	}
+	groups, err := a.store.Cardinality(c.Request.Context(), q, field, by, size)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if by == "" {
+		c.JSON(200, gin.H{"field": field, "count": groups[0].Count})
+		return
+	}
+	c.JSON(200, gin.H{"field": field, "by": by, "groups": groups})
+}
+
+func aggregation(c *gin.Context) (storage.LogQuery, string, int, error) {
+	q, err := logQuery(c)
+	if err != nil {
+		return q, "", 0, err
+	}
+	field := c.Query("field")
+	if !parser.KnownField(field) {
+		return q, "", 0, invalid(fmt.Errorf("field: unknown field %q", field))
+	}
+	size, err := intParam(c, "size")
+	return q, field, size, err
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/siem/internal/storage"
+)
+
+// listMetrics returns the metrics reported per host, of one host with
+// host set.
+func (a *API) listMetrics(c *gin.Context) {
+	names, err := a.store.MetricNames(c.Request.Context(), c.Query("host"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"metrics": names})
+}
+
+// metricRange returns the series of the metrics of one host. Parameters:
+// host, metric (comma separated or repeated), from and to (RFC 3339,
+// default the last hour) and step (a Go duration; by default the range is
+// split into about 500 points). Ranges reaching back more than a day, or
+// with a step of a minute or more, come from the one-minute rollups.
+func (a *API) metricRange(c *gin.Context) {
+	host, metrics := c.Query("host"), list(c, "metric")
+	if host == "" {
+		fail(c, invalid(errors.New("host: required")))
+		return
+	}
+	if len(metrics) == 0 {
+		fail(c, invalid(errors.New("metric: required")))
+		return
+	}
+	q := storage.MetricQuery{Host: host}
+	var err error
+	if q.From, err = timeParam(c, "from"); err != nil {
+		fail(c, err)
+		return
+	}
+	if q.To, err = timeParam(c, "to"); err != nil {
+		fail(c, err)
+		return
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		fail(c, invalid(errors.New("from: must be before to")))
+		return
+	}
+	if v := c.Query("step"); v != "" {
+		if q.Step, err = time.ParseDuration(v); err != nil || q.Step < time.Second {
+			fail(c, invalid(fmt.Errorf("step: expected a duration of at least 1s")))
+			return
+		}
+	}
+
+	type series struct {
+		storage.MetricSeries
+		Step string `json:"step"`
+	}
+	out := make([]series, 0, len(metrics))
+	for _, metric := range metrics {
+		q.Metric = metric
+		s, err := a.store.MetricRange(c.Request.Context(), q)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		out = append(out, series{s, s.Step.String()})
+	}
+	c.JSON(200, gin.H{"series": out})
+}
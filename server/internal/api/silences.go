@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/siem/internal/alerting"
+	"github.com/siem/internal/storage"
+)
+
+// listSilences returns active silences, or all with ?all=true.
+func (a *API) listSilences(c *gin.Context) {
+	all := c.Query("all") == "true"
+	silences, err := a.store.ListSilences(c.Request.Context(), all)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if silences == nil {
+		silences = []storage.Silence{}
+	}
+	c.JSON(200, gin.H{"silences": silences})
+}
+
+type silenceRequest struct {
+	Matchers  []storage.Matcher `json:"matchers"`
+	Comment   string            `json:"comment"`
+	CreatedBy string            `json:"created_by"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// Duration such as "2h" may be given instead of ExpiresAt.
+	Duration string `json:"duration"`
+}
+
+func (a *API) createSilence(c *gin.Context) {
+	var req silenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			fail(c, invalid(fmt.Errorf("duration: %w", err)))
+			return
+		}
+		req.ExpiresAt = time.Now().Add(d)
+	}
+
+	s := storage.Silence{
+		Matchers:  req.Matchers,
+		Comment:   req.Comment,
+		CreatedBy: req.CreatedBy,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := alerting.Validate(s); err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	s, err := a.store.CreateSilence(c.Request.Context(), s)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	a.reloadSilences(c)
+	c.JSON(201, s)
+}
+
+// expireSilence ends a silence; it stays listed with ?all=true.
+func (a *API) expireSilence(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		fail(c, invalid(fmt.Errorf("invalid silence id %q", c.Param("id"))))
+		return
+	}
+	s, err := a.store.ExpireSilence(c.Request.Context(), uint(id))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	a.reloadSilences(c)
+	c.JSON(200, s)
+}
+
+func (a *API) reloadSilences(c *gin.Context) {
+	if err := a.silences.Reload(c.Request.Context()); err != nil {
+		c.Error(err)
+	}
+}
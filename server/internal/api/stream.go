@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/siem/internal/query"
+	"github.com/siem/internal/storage"
+	"github.com/siem/internal/stream"
+)
+
+const (
+	streamPing         = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+var streamUpgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+// streamFilter is the filter message of a stream client. A stream that
+// is left out is not sent; an empty query matches everything.
+//
+//	{"logs": {"query": "event_type:ssh_failed"}, "alerts": {}}
+type streamFilter struct {
+	Logs   *streamQuery `json:"logs"`
+	Alerts *streamQuery `json:"alerts"`
+}
+
+type streamQuery struct {
+	Query string `json:"query"`
+}
+
+func (f streamFilter) compile() (stream.Filter, error) {
+	var out stream.Filter
+	var err error
+	if f.Logs != nil {
+		out.Logs = true
+		if out.LogQuery, err = parseQuery(f.Logs.Query, storage.LogSchema); err != nil {
+			return out, fmt.Errorf("logs: %w", err)
+		}
+	}
+	if f.Alerts != nil {
+		out.Alerts = true
+		if out.AlertQuery, err = parseQuery(f.Alerts.Query, storage.AlertSchema); err != nil {
+			return out, fmt.Errorf("alerts: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// stream sends new logs and alerts as they are stored. WebSocket clients
+// send a streamFilter message, and may send another to change it, before
+// they receive anything. Other clients get Server-Sent Events filtered by
+// the logs and alerts query parameters, each selecting its stream when
+// present; without either they get everything.
+func (a *API) stream(c *gin.Context) {
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		a.streamWebSocket(c)
+		return
+	}
+
+	var f streamFilter
+	if v, ok := c.GetQuery("logs"); ok {
+		f.Logs = &streamQuery{Query: v}
+	}
+	if v, ok := c.GetQuery("alerts"); ok {
+		f.Alerts = &streamQuery{Query: v}
+	}
+	if f.Logs == nil && f.Alerts == nil {
+		f = streamFilter{Logs: &streamQuery{}, Alerts: &streamQuery{}}
+	}
+	filter, err := f.compile()
+	if err != nil {
+		fail(c, invalid(err))
+		return
+	}
+	a.streamSSE(c, filter)
+}
+
+func (a *API) streamSSE(c *gin.Context, f stream.Filter) {
+	sub := a.hub.Subscribe(f)
+	defer a.hub.Unsubscribe(sub)
+
+	w := c.Writer
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(200)
+
+	write := func(ev stream.Event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ping.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err := w.WriteString(": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case ev := <-sub.Events():
+			if n := sub.TakeDropped(); n > 0 {
+				if err := write(stream.Event{Type: "dropped", Dropped: n}); err != nil {
+					return
+				}
+			}
+			if err := write(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (a *API) streamWebSocket(c *gin.Context) {
+	conn, err := streamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("Stream WS error:", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := a.hub.Subscribe(stream.Filter{})
+	defer a.hub.Unsubscribe(sub)
+
+	// The reader applies filter messages and reports their errors
+	// through the writer, which is the only one writing to conn.
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var (
+				f      streamFilter
+				filter stream.Filter
+			)
+			err = json.Unmarshal(msg, &f)
+			if err == nil {
+				filter, err = f.compile()
+			}
+			if err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+				continue
+			}
+			sub.SetFilter(filter)
+		}
+	}()
+
+	write := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case err := <-errs:
+			body := gin.H{"type": "error", "error": err.Error()}
+			var syntax *query.SyntaxError
+			if errors.As(err, &syntax) {
+				body["offset"] = syntax.Offset
+			}
+			if write(body) != nil {
+				return
+			}
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)) != nil {
+				return
+			}
+		case ev := <-sub.Events():
+			if n := sub.TakeDropped(); n > 0 {
+				if write(stream.Event{Type: "dropped", Dropped: n}) != nil {
+					return
+				}
+			}
+			if write(ev) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the current agent ingest protocol version. Batches without a
+// version are legacy batches, answered with a plain "OK" frame.
+const Version = 2
+
+// Batch is one agent message. Seq is monotonic per AgentID and Checksum is
+// the hex SHA-256 of the Batch payload bytes as sent.
+type Batch struct {
+	Type     string          `json:"type"`
+	Version  int             `json:"v"`
+	AgentID  string          `json:"agent_id"`
+	Seq      uint64          `json:"seq"`
+	Checksum string          `json:"checksum"`
+	Host     string          `json:"host"`
+	Batch    json.RawMessage `json:"batch"`
+}
+
+// Reply acknowledges a Batch by sequence number. A nack with Retry set
+// asks the agent to send the batch again; without it the batch is
+// rejected for good.
+type Reply struct {
+	Type      string `json:"type"`
+	Seq       uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Retry     bool   `json:"retry,omitempty"`
+}
+
+func Checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *Batch) Verify() error {
+	if b.AgentID == "" {
+		return fmt.Errorf("missing agent_id")
+	}
+	if b.Seq == 0 {
+		return fmt.Errorf("missing seq")
+	}
+	if got := Checksum(b.Batch); got != b.Checksum {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+func Ack(seq uint64, duplicate bool) Reply {
+	return Reply{Type: "ack", Seq: seq, Duplicate: duplicate}
+}
+
+func Nack(seq uint64, err error, retry bool) Reply {
+	return Reply{Type: "nack", Seq: seq, Error: err.Error(), Retry: retry}
+}
@@ -0,0 +1,251 @@
+package parser
+
+import (
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// type=SYSCALL msg=audit(1700000000.123:4567): key=value ..., with the
+// node=host prefix of name_format.
+var auditRecordRe = regexp.MustCompile(`^(?:node=(\S+) )?type=(\S+) msg=audit\([\d.]+:\d+\): ?`)
+
+// auditUntrusted are the fields auditd writes hex-encoded when they hold
+// spaces, quotes or control characters; otherwise they are quoted.
+var auditUntrusted = map[string]bool{
+	"proctitle": true, "name": true, "cwd": true, "exe": true, "comm": true,
+	"acct": true, "key": true, "path": true, "cmd": true,
+}
+
+// chmodModeArgs are the chmod syscalls by the index of their mode
+// argument; auditSyscalls names them by arch and number for logs without
+// enriched names.
+var (
+	chmodModeArgs = map[string]int{"chmod": 1, "fchmod": 1, "fchmodat": 2, "fchmodat2": 2}
+	auditSyscalls = map[string]string{
+		"c000003e:90": "chmod", "c000003e:91": "fchmod", "c000003e:268": "fchmodat", "c000003e:452": "fchmodat2", // x86_64
+		"c00000b7:52": "fchmod", "c00000b7:53": "fchmodat", "c00000b7:452": "fchmodat2", // aarch64
+	}
+)
+
+// auditdParser reads auditd events, as the agent sends them with the
+// records of one event on separate lines, into one audit event. The
+// command line comes from EXECVE or PROCTITLE; the type of the first
+// record goes into the audit_type field. For chmod syscalls the new mode
+// goes into the mode field in octal, e.g. 4755.
+type auditdParser struct{}
+
+func (auditdParser) Name() string { return "auditd" }
+
+func (auditdParser) Parse(msg string, l *NormalizedLog) bool {
+	var (
+		fields   = map[string]string{}
+		enriched = map[string]string{} // names after \x1d, e.g. AUID="alice"
+		syscall  map[string]string
+		args     []string
+		title    string
+		pathSet  bool
+		node     string
+		client   string
+	)
+	for i, line := range strings.Split(msg, "\n") {
+		m := auditRecordRe.FindStringSubmatch(line)
+		if m == nil {
+			return false
+		}
+		typ := m[2]
+		if i == 0 {
+			fields["audit_type"] = typ
+			node = m[1]
+		}
+		raw, extra, _ := strings.Cut(line[len(m[0]):], "\x1d")
+		kv := auditValues(raw, typ == "EXECVE")
+		for k, v := range auditValues(extra, false) {
+			enriched[k] = v
+		}
+
+		switch typ {
+		case "EXECVE":
+			args = auditArgs(kv)
+		case "PROCTITLE":
+			title = strings.ReplaceAll(kv["proctitle"], "\x00", " ")
+		case "CWD":
+			fields["cwd"] = kv["cwd"]
+		case "PATH":
+			// The first PATH that isn't a parent directory is what the
+			// syscall worked on.
+			if !pathSet && kv["name"] != "" {
+				fields["path"] = kv["name"]
+				fields["nametype"] = kv["nametype"]
+				pathSet = kv["nametype"] != "PARENT"
+			}
+		default:
+			// SYSCALL, USER_*, CONFIG_CHANGE and others; USER_* records
+			// have their details in a nested msg='...'.
+			if typ == "SYSCALL" {
+				syscall = kv
+			}
+			if inner, ok := kv["msg"]; ok {
+				for k, v := range auditValues(inner, false) {
+					kv[k] = v
+				}
+			}
+			for _, k := range []string{
+				"syscall", "success", "exit", "pid", "ppid", "auid", "uid", "euid",
+				"ses", "tty", "comm", "exe", "key", "op", "acct", "terminal", "res",
+			} {
+				if v, ok := kv[k]; ok && fields[k] == "" {
+					fields[k] = v
+				}
+			}
+			if ip := cmp.Or(kv["addr"], kv["hostname"]); ip != "" && ip != "?" {
+				client = ip
+			}
+		}
+	}
+
+	l.EventType = "audit"
+	if l.Hostname == "" {
+		l.Hostname = node
+	}
+	if client != "" {
+		setClient(l, client, "")
+	}
+	if mode, ok := chmodMode(syscall, enriched["SYSCALL"]); ok {
+		fields["mode"] = mode
+	}
+	if name := enriched["SYSCALL"]; name != "" {
+		fields["syscall"] = name
+	}
+	if len(args) > 0 {
+		fields["command"] = strings.Join(args, " ")
+	} else if title != "" {
+		fields["command"] = title
+	}
+	if fields["key"] == "(null)" {
+		delete(fields, "key")
+	}
+	l.Pid, _ = strconv.Atoi(fields["pid"])
+	delete(fields, "pid")
+	if user := enriched["AUID"]; user != "" && user != "unset" {
+		l.User = user
+	} else if acct := fields["acct"]; acct != "" && acct != "?" {
+		l.User = acct
+	} else if auid := fields["auid"]; auid != "" && auid != "4294967295" {
+		// Logs without enriched names only have the numeric id.
+		l.User = auid
+	}
+	if fields["success"] == "no" || fields["res"] == "failed" {
+		l.Level = "warn"
+	} else {
+		l.Level = "info"
+	}
+	for k, v := range fields {
+		if v != "" {
+			setField(l, k, v)
+		}
+	}
+	return true
+}
+
+// chmodMode returns the mode a chmod syscall set, given its SYSCALL record
+// and enriched name.
+func chmodMode(kv map[string]string, name string) (string, bool) {
+	if kv == nil {
+		return "", false
+	}
+	if name == "" {
+		name = auditSyscalls[kv["arch"]+":"+kv["syscall"]]
+	}
+	i, ok := chmodModeArgs[name]
+	if !ok {
+		return "", false
+	}
+	mode, err := strconv.ParseUint(kv["a"+strconv.Itoa(i)], 16, 64)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%04o", mode&0o7777), true
+}
+
+// auditValues splits key=value pairs. Values are bare, "quoted",
+// 'quoted' (the nested msg of user space records) or, for untrusted
+// fields and the arguments of EXECVE records (args), hex. The a0, a1, ...
+// of other records are syscall arguments in plain hex and kept as they are.
+func auditValues(s string, args bool) map[string]string {
+	kv := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " ") {
+		eq := strings.IndexAny(s, "= ")
+		if eq < 0 || s[eq] != '=' {
+			// A word without value.
+			_, s, _ = strings.Cut(s, " ")
+			continue
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if s != "" && (s[0] == '"' || s[0] == '\'') {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value, s = s[1:end+1], s[min(end+2, len(s)):]
+		} else {
+			value, s, _ = strings.Cut(s, " ")
+			if auditUntrusted[key] || args && strings.HasPrefix(key, "a") && isAuditArg(key[1:]) {
+				value = auditHex(value)
+			}
+		}
+		kv[key] = value
+	}
+	return kv
+}
+
+// isAuditArg reports whether an EXECVE key without its "a" is an
+// argument: "0" or "1[2]" of an argument split over records.
+func isAuditArg(s string) bool {
+	n, _, _ := strings.Cut(s, "[")
+	_, err := strconv.Atoi(n)
+	return err == nil
+}
+
+// auditArgs returns the EXECVE arguments a0, a1, ... in order, joining
+// long arguments that auditd split into a1[0], a1[1], ...
+func auditArgs(kv map[string]string) []string {
+	argc, err := strconv.Atoi(kv["argc"])
+	if err != nil {
+		return nil
+	}
+	args := make([]string, 0, argc)
+	for i := range argc {
+		key := "a" + strconv.Itoa(i)
+		if v, ok := kv[key]; ok {
+			args = append(args, v)
+			continue
+		}
+		var b strings.Builder
+		for j := 0; ; j++ {
+			v, ok := kv[key+"["+strconv.Itoa(j)+"]"]
+			if !ok {
+				break
+			}
+			b.WriteString(v)
+		}
+		args = append(args, b.String())
+	}
+	return args
+}
+
+func auditHex(s string) string {
+	if len(s)%2 != 0 {
+		return s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return string(b)
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func auditEvent(records ...string) string {
+	return strings.Join(records, "\n")
+}
+
+func TestAuditdParseReassembled(t *testing.T) {
+	msg := auditEvent(
+		`node=web1 type=SYSCALL msg=audit(1700000000.123:100): arch=c000003e syscall=59 success=yes exit=0 a0=55d0 a1=55d1 a2=55d2 a3=0 items=2 ppid=1 pid=4242 auid=1000 uid=0 euid=0 tty=pts0 ses=3 comm="cat" exe="/usr/bin/cat" key="exec"`+"\x1d"+`ARCH=x86_64 SYSCALL=execve AUID="alice" UID="root"`,
+		// "/tmp/my file", hex-encoded for its space and split over two
+		// parts as auditd does for long arguments.
+		`node=web1 type=EXECVE msg=audit(1700000000.123:100): argc=3 a0="cat" a1_len=12 a1[0]=2f746d702f6d79 a1[1]=2066696c65 a2="-n"`,
+		`node=web1 type=CWD msg=audit(1700000000.123:100): cwd="/root"`,
+		`node=web1 type=PATH msg=audit(1700000000.123:100): item=0 name=2f746d702f6d792066696c65 inode=12 nametype=NORMAL`,
+		`node=web1 type=PROCTITLE msg=audit(1700000000.123:100): proctitle=636174002F746D70`,
+	)
+	var l NormalizedLog
+	if !(auditdParser{}).Parse(msg, &l) {
+		t.Fatal("Parse = false")
+	}
+	if l.EventType != "audit" || l.Hostname != "web1" || l.User != "alice" || l.Pid != 4242 || l.Level != "info" {
+		t.Errorf("got event_type=%q hostname=%q user=%q pid=%d level=%q", l.EventType, l.Hostname, l.User, l.Pid, l.Level)
+	}
+	want := map[string]string{
+		"audit_type": "SYSCALL",
+		"syscall":    "execve",
+		"success":    "yes",
+		"exit":       "0",
+		"ppid":       "1",
+		"auid":       "1000",
+		"uid":        "0",
+		"euid":       "0",
+		"ses":        "3",
+		"tty":        "pts0",
+		"comm":       "cat",
+		"exe":        "/usr/bin/cat",
+		"key":        "exec",
+		"command":    "cat /tmp/my file -n",
+		"cwd":        "/root",
+		"path":       "/tmp/my file",
+		"nametype":   "NORMAL",
+	}
+	if !maps.Equal(l.Fields, want) {
+		t.Errorf("fields = %v, want %v", l.Fields, want)
+	}
+}
+
+func TestAuditdParseChmodMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		syscall  string
+		enriched string
+		mode     string
+	}{
+		// fchmodat(AT_FDCWD, "b", 04755) of install -m 4755, the mode in
+		// a2 as plain hex.
+		{"fchmodat", `arch=c000003e syscall=268 success=yes exit=0 a0=ffffff9c a1=5600 a2=9ed a3=0`, "", "4755"},
+		{"fchmod", `arch=c000003e syscall=91 success=yes exit=0 a0=3 a1=1a4 a2=0 a3=0`, "", "0644"},
+		{"enriched", `arch=c00000b7 syscall=53 success=yes exit=0 a0=ffffff9c a1=5600 a2=5ed a3=0`, "\x1dSYSCALL=fchmodat", "2755"},
+		{"not chmod", `arch=c000003e syscall=2 success=yes exit=3 a0=5600 a1=9ed a2=0 a3=0`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := auditEvent(
+				`type=SYSCALL msg=audit(1700000000.5:7): `+tt.syscall+` items=1 ppid=1 pid=10 auid=1000 uid=0 comm="install" exe="/usr/bin/install" key="perm_mod"`+tt.enriched,
+				`type=PROCTITLE msg=audit(1700000000.5:7): proctitle=696E7374616C6C002D6D003437353500610062`,
+			)
+			var l NormalizedLog
+			if !(auditdParser{}).Parse(msg, &l) {
+				t.Fatal("Parse = false")
+			}
+			if got := l.Fields["mode"]; got != tt.mode {
+				t.Errorf("mode = %q, want %q", got, tt.mode)
+			}
+			if got := l.Fields["command"]; got != "install -m 4755 a b" {
+				t.Errorf("command = %q", got)
+			}
+			if l.User != "1000" {
+				t.Errorf("user = %q, want the numeric auid", l.User)
+			}
+		})
+	}
+}
+
+func TestAuditdParseLeavesLogUnchanged(t *testing.T) {
+	msg := auditEvent(
+		`node=bastion type=USER_LOGIN msg=audit(1700000000.1:9): pid=1 uid=0 auid=4294967295 ses=4294967295 msg='op=login acct="root" exe="/usr/sbin/sshd" hostname=? addr=203.0.113.9 terminal=sshd res=failed'`,
+		`not an audit record`,
+	)
+	l := NormalizedLog{Source: "audit.log", Message: msg}
+	want := l
+	if (auditdParser{}).Parse(msg, &l) {
+		t.Fatal("Parse = true")
+	}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("l = %+v, want it unchanged", l)
+	}
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default is the registry of ParseLog, with the built-in parsers.
+var Default = &Registry{}
+
+func init() {
+	Default.Register(fimParser{}, Selector{
+		Priority: 70,
+		Sources:  []string{"fim"},
+	})
+	Default.Register(auditdParser{}, Selector{
+		Priority: 60,
+		Sources:  []string{"audit.log*"},
+		Sniff:    func(msg string) bool { return strings.Contains(msg, "msg=audit(") },
+	})
+	Default.Register(accessLogParser{}, Selector{
+		Priority: 50,
+		Sources:  []string{"*access.log*", "*access_log*"},
+		Sniff:    sniffAccessLog,
+	})
+	Default.Register(webErrorLogParser{}, Selector{
+		Priority: 40,
+		Sources:  []string{"*error.log*", "*error_log*"},
+		Sniff:    sniffWebErrorLog,
+	})
+	Default.Register(sshdParser{}, Selector{
+		Priority: 30,
+		Sources:  []string{"auth.log*", "secure*"},
+		Programs: []string{"sshd"},
+		Sniff:    func(line string) bool { return strings.Contains(line, " from ") },
+	})
+	Default.Register(sudoParser{}, Selector{
+		Priority: 20,
+		Programs: []string{"sudo"},
+		Sniff:    func(line string) bool { return strings.Contains(line, "sudo:") },
+	})
+	Default.Register(metricsParser{}, Selector{
+		Priority: 10,
+		Sniff:    func(line string) bool { return strings.Contains(line, "CPU:") },
+	})
+}
+
+var (
+	// Failed password for invalid user admin from 1.2.3.4 port 22 ssh2
+	// Invalid user admin from 1.2.3.4 port 22
+	sshFailedRe = regexp.MustCompile(`(?:Failed \S+ for (?:invalid user )?|[Ii]nvalid user )(\S*) from (\S+?)(?: port (\d+))?(?:\s|$)`)
+	// Accepted publickey for root from 1.2.3.4 port 22 ssh2
+	sshAcceptedRe = regexp.MustCompile(`Accepted (\S+) for (\S+) from (\S+?)(?: port (\d+))?(?:\s|$)`)
+	// sudo:   alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/ls
+	// sudo:   alice : 3 incorrect password attempts ; TTY=pts/0 ; PWD=...
+	sudoRe   = regexp.MustCompile(`(\S+) : (?:([^;]+) ; )?TTY=(\S+) ; PWD=`)
+	cpuMemRe = regexp.MustCompile(`CPU:([\d.]+)% MEM:([\d.]+)%`)
+)
+
+// sshdParser reads sshd authentication results into ssh_failed and
+// ssh_success events. The client port goes into the src_port field.
+type sshdParser struct{}
+
+func (sshdParser) Name() string { return "sshd" }
+
+func (sshdParser) Parse(line string, l *NormalizedLog) bool {
+	if m := sshFailedRe.FindStringSubmatch(line); m != nil {
+		l.EventType = "ssh_failed"
+		l.User = m[1]
+		setClient(l, m[2], m[3])
+		return true
+	}
+	if m := sshAcceptedRe.FindStringSubmatch(line); m != nil {
+		l.EventType = "ssh_success"
+		l.Level = "info"
+		l.User = m[2]
+		setClient(l, m[3], m[4])
+		setField(l, "method", m[1])
+		return true
+	}
+	return false
+}
+
+// setClient sets the client address, which older sshd and some tools
+// write as ip:port.
+func setClient(l *NormalizedLog, addr, port string) {
+	if ap, err := netip.ParseAddrPort(addr); err == nil && port == "" {
+		addr, port = ap.Addr().String(), strconv.Itoa(int(ap.Port()))
+	}
+	if _, err := netip.ParseAddr(addr); err == nil {
+		l.SrcIP = addr
+	}
+	if port != "" {
+		setField(l, "src_port", port)
+	}
+}
+
+// sudoParser reads sudo's command log into sudo events for commands that
+// ran and sudo_failed events for denied ones, with sudo's reason, e.g.
+// "user NOT in sudoers", in the reason field. The terminal goes into the
+// tty field.
+type sudoParser struct{}
+
+func (sudoParser) Name() string { return "sudo" }
+
+func (sudoParser) Parse(line string, l *NormalizedLog) bool {
+	m := sudoRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	l.EventType = "sudo"
+	if m[2] != "" {
+		l.EventType = "sudo_failed"
+		setField(l, "reason", m[2])
+	}
+	l.User = m[1]
+	setField(l, "tty", m[3])
+	return true
+}
+
+// metricsParser reads the "CPU:x% MEM:y%" lines of agents that don't send
+// structured metrics yet.
+type metricsParser struct{}
+
+func (metricsParser) Name() string { return "metrics" }
+
+func (metricsParser) Parse(line string, l *NormalizedLog) bool {
+	m := cpuMemRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	cpu, err1 := strconv.ParseFloat(m[1], 64)
+	mem, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	SetMetrics(l, map[string]float64{"cpu": cpu, "mem": mem})
+	return true
+}
+
+// SetMetrics makes l a metrics event with the values an agent sent along
+// with the entry, e.g. cpu, mem and load1.
+func SetMetrics(l *NormalizedLog, metrics map[string]float64) {
+	l.EventType = "metrics"
+	l.Level = "info"
+	l.Metrics = metrics
+}
+
+func setField(l *NormalizedLog, name, value string) {
+	if l.Fields == nil {
+		l.Fields = map[string]string{}
+	}
+	l.Fields[name] = value
+}
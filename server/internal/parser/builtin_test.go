@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestSudoParse(t *testing.T) {
+	tests := []struct {
+		line   string
+		event  string
+		fields map[string]string
+	}{
+		{
+			"Jan  2 03:04:05 web1 sudo:    alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash",
+			"sudo",
+			map[string]string{"tty": "pts/0"},
+		},
+		{
+			"Jan  2 03:04:05 web1 sudo:    alice : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash",
+			"sudo_failed",
+			map[string]string{"tty": "pts/0", "reason": "3 incorrect password attempts"},
+		},
+		{
+			"Jan  2 03:04:05 web1 sudo:      bob : user NOT in sudoers ; TTY=pts/1 ; PWD=/tmp ; USER=root ; COMMAND=/bin/sh",
+			"sudo_failed",
+			map[string]string{"tty": "pts/1", "reason": "user NOT in sudoers"},
+		},
+	}
+	for _, tt := range tests {
+		l := ParseLog("auth.log", tt.line)
+		if l.EventType != tt.event || !maps.Equal(l.Fields, tt.fields) {
+			t.Errorf("%q: event_type %q, fields %v; want %q, %v", tt.line, l.EventType, l.Fields, tt.event, tt.fields)
+		}
+	}
+}
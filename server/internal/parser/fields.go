@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldPrefix selects an entry of NormalizedLog.Fields by name, e.g.
+// "fields.path".
+const FieldPrefix = "fields."
+
+// MetricPrefix selects a value of NormalizedLog.Metrics by name, e.g.
+// "metrics.cpu".
+const MetricPrefix = "metrics."
+
+// SDPrefix selects a parameter of StructuredData as "sd.<SD-ID>.<name>",
+// e.g. "sd.origin.ip".
+const SDPrefix = "sd."
+
+// Field returns a field by its JSON name, a Fields entry for names
+// starting with FieldPrefix, a metric for names starting with
+// MetricPrefix, or a structured data parameter for names starting with
+// SDPrefix.
+func (l NormalizedLog) Field(name string) (string, bool) {
+	switch name {
+	case "ts":
+		return l.Timestamp.Format(time.RFC3339Nano), true
+	case "ingest_ts":
+		return l.IngestTime.Format(time.RFC3339Nano), true
+	case "host":
+		return l.Host, true
+	case "source":
+		return l.Source, true
+	case "msg":
+		return l.Message, true
+	case "level":
+		return l.Level, true
+	case "event_type":
+		return l.EventType, true
+	case "src_ip":
+		return l.SrcIP, true
+	case "dst_port":
+		return l.DstPort, true
+	case "user":
+		return l.User, true
+	case "pid":
+		return strconv.Itoa(l.Pid), true
+	case "raw":
+		return l.Raw, true
+	case "hostname":
+		return l.Hostname, true
+	case "program":
+		return l.Program, true
+	case "facility":
+		return l.Facility, true
+	case "severity":
+		return l.Severity, true
+	}
+	if key, ok := strings.CutPrefix(name, FieldPrefix); ok {
+		v, ok := l.Fields[key]
+		return v, ok
+	}
+	if key, ok := strings.CutPrefix(name, MetricPrefix); ok {
+		v, ok := l.Metrics[key]
+		if !ok {
+			return "", false
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	if id, param, ok := SDParam(name); ok {
+		v, ok := l.StructuredData[id][param]
+		return v, ok
+	}
+	return "", false
+}
+
+// KnownField reports whether name can be passed to Field.
+func KnownField(name string) bool {
+	switch name {
+	case "ts", "ingest_ts", "host", "source", "msg", "level", "event_type",
+		"src_ip", "dst_port", "user", "pid", "raw", "hostname", "program",
+		"facility", "severity":
+		return true
+	}
+	if _, _, ok := SDParam(name); ok {
+		return true
+	}
+	for _, prefix := range []string{FieldPrefix, MetricPrefix} {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SDParam splits a field name starting with SDPrefix into SD-ID and
+// parameter name.
+func SDParam(name string) (id, param string, ok bool) {
+	rest, ok := strings.CutPrefix(name, SDPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, param, ok = strings.Cut(rest, ".")
+	return id, param, ok && id != "" && param != ""
+}
+
+// Values returns all fields keyed by JSON name, with the Fields entries
+// nested under "fields", the metrics, as numbers, under "metrics" and
+// structured data under "sd". It is meant for message templates.
+func (l NormalizedLog) Values() map[string]any {
+	fields := make(map[string]any, len(l.Fields))
+	for k, v := range l.Fields {
+		fields[k] = v
+	}
+	return map[string]any{
+		"ts":         l.Timestamp,
+		"ingest_ts":  l.IngestTime,
+		"host":       l.Host,
+		"source":     l.Source,
+		"msg":        l.Message,
+		"level":      l.Level,
+		"event_type": l.EventType,
+		"src_ip":     l.SrcIP,
+		"dst_port":   l.DstPort,
+		"user":       l.User,
+		"pid":        l.Pid,
+		"raw":        l.Raw,
+		"hostname":   l.Hostname,
+		"program":    l.Program,
+		"facility":   l.Facility,
+		"severity":   l.Severity,
+		"sd":         l.StructuredData,
+		"fields":     fields,
+		"metrics":    l.Metrics,
+	}
+}
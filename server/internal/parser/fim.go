@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fimParser reads the file integrity events of the agent:
+//
+//	fim: modified path="/etc/passwd" old_hash=... old_mode=-rw-r--r-- old_owner=0:0 ... new_hash=...
+//
+// The action (added, modified, deleted or permissions) goes into the
+// action field, the key=value pairs into fields of their name. Only
+// entries of source fim are read, so lines of log files can't pass for
+// FIM events.
+type fimParser struct{}
+
+func (fimParser) Name() string { return "fim" }
+
+func (fimParser) Parse(msg string, l *NormalizedLog) bool {
+	rest, ok := strings.CutPrefix(msg, "fim: ")
+	if !ok || l.Source != "fim" {
+		return false
+	}
+	action, rest, _ := strings.Cut(rest, " ")
+	switch action {
+	case "added", "modified", "deleted", "permissions":
+	default:
+		return false
+	}
+	fields := map[string]string{"action": action}
+	for rest = strings.TrimLeft(rest, " "); rest != ""; rest = strings.TrimLeft(rest, " ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return false
+		}
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return false
+			}
+			rest = value[len(quoted):]
+			value, _ = strconv.Unquote(quoted)
+		} else {
+			value, rest, _ = strings.Cut(value, " ")
+		}
+		fields[key] = value
+	}
+	if fields["path"] == "" {
+		return false
+	}
+
+	l.EventType = "fim"
+	l.Level = "warn"
+	for k, v := range fields {
+		setField(l, k, v)
+	}
+	return true
+}
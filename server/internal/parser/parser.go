@@ -1,79 +1,43 @@
 package parser
 
 import (
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
 
 type NormalizedLog struct {
-	Timestamp time.Time `json:"ts"`
-	Host      string    `json:"host"`
-	Source    string    `json:"source"`
-	Message   string    `json:"msg"`
-	Level     string    `json:"level"`
-	EventType string    `json:"event_type"`
-	SrcIP     string    `json:"src_ip"`
-	DstPort   string    `json:"dst_port"`
-	User      string    `json:"user"`
-	Pid       int       `json:"pid"`
-	Raw       string    `json:"raw"`
+	// Timestamp is the event time: from the line itself if it has one,
+	// else the agent's read time, else IngestTime.
+	Timestamp  time.Time `json:"ts"`
+	IngestTime time.Time `json:"ingest_ts"` // when the server received it
+	Host       string    `json:"host"`
+	Source     string    `json:"source"`
+	Message    string    `json:"msg"`
+	Level      string    `json:"level"`
+	EventType  string    `json:"event_type"`
+	SrcIP      string    `json:"src_ip"`
+	DstPort    string    `json:"dst_port"`
+	User       string    `json:"user"`
+	Pid        int       `json:"pid"`
+	Raw        string    `json:"raw"`
+	// Syslog header; Host is the agent's host, Hostname the one the
+	// line names.
+	Hostname string `json:"hostname,omitempty"`
+	Program  string `json:"program,omitempty"`
+	Facility string `json:"facility,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// StructuredData holds RFC 5424 structured data by SD-ID.
+	StructuredData map[string]map[string]string `json:"sd,omitempty"`
+	// Fields holds format-specific values that have no column of their
+	// own, e.g. "path" and "action" of fim events.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Metrics holds the values of metrics events, e.g. "cpu" and "mem".
+	Metrics map[string]float64 `json:"metrics,omitempty"`
 }
 
-var (
-	sshFailedRe   = regexp.MustCompile(`Failed password|invalid user (.+?) from ([\d.]+)(?::(\d+))?`)
-	authSuccessRe = regexp.MustCompile(`Accepted password|Accepted publickey for (.+?) from ([\d.]+)`)
-	sudoRe        = regexp.MustCompile(`sudo: +(.+?) : ([\w-]+) ; TTY=pts/(\d+) ; PWD=`)
-	cpuMemRe      = regexp.MustCompile(`CPU:([\d.]+)% MEM:([\d.]+)%`)
-)
-
+// ParseLog normalizes a line read from source with the Default registry.
 func ParseLog(source, line string) NormalizedLog {
-	log := NormalizedLog{
-		Source:  source,
-		Message: strings.TrimSpace(line),
-		Raw:     line,
-		Level:   parseLevel(line),
-	}
-
-	// SSH Failed
-	if match := sshFailedRe.FindStringSubmatch(line); len(match) >= 3 {
-		log.EventType = "ssh_failed"
-		log.User = match[1]
-		log.SrcIP = match[2]
-		if len(match) > 3 && match[3] != "" {
-			port, _ := strconv.Atoi(match[3])
-			log.DstPort = strconv.Itoa(port)
-		}
-		return log
-	}
-
-	// SSH Success
-	if match := authSuccessRe.FindStringSubmatch(line); len(match) >= 3 {
-		log.EventType = "ssh_success"
-		log.User = match[1]
-		log.SrcIP = match[2]
-		log.Level = "info"
-		return log
-	}
-
-	// Sudo
-	if match := sudoRe.FindStringSubmatch(line); len(match) >= 4 {
-		log.EventType = "sudo"
-		log.User = match[1]
-		log.Pid, _ = strconv.Atoi(match[3])
-		return log
-	}
-
-	// Metrics
-	if match := cpuMemRe.FindStringSubmatch(line); len(match) >= 3 {
-		log.EventType = "metrics"
-		log.Level = "info"
-		return log
-	}
-
-	log.EventType = "generic"
-	return log
+	return Default.Parse(source, line)
 }
 
 func parseLevel(line string) string {
@@ -81,7 +45,7 @@ func parseLevel(line string) string {
 	switch {
 	case strings.Contains(l, "error"), strings.Contains(l, "failed"):
 		return "error"
-	case strings.Contains(l, "warn", "warning"):
+	case strings.Contains(l, "warn"):
 		return "warn"
 	case strings.Contains(l, "auth"), strings.Contains(l, "sudo"), strings.Contains(l, "password"):
 		return "security"
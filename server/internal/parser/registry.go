@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Parser recognizes one log format.
+type Parser interface {
+	Name() string
+	// Parse reads msg, the line without its syslog header. It fills the
+	// format-specific fields of l, which already has Source, Message, Raw,
+	// Level and the syslog header fields, and puts values without a field
+	// of their own into l.Fields. It reports false for messages it
+	// doesn't recognize, leaving l unchanged.
+	Parse(msg string, l *NormalizedLog) bool
+}
+
+// Selector decides which lines a parser is tried on. A parser without
+// sources, programs or sniff is tried on every line.
+type Selector struct {
+	// Priority orders the parsers tried on a line, highest first.
+	Priority int
+	// Programs are the syslog program names of the parser's lines.
+	Programs []string
+	// Sources are path.Match patterns of the log source. Patterns
+	// without a slash match the file name, e.g. "auth.log*".
+	Sources []string
+	// Sniff is a cheap content check of the message, for lines of any
+	// source.
+	Sniff func(msg string) bool
+}
+
+func (s *Selector) selects(l *NormalizedLog, msg string) bool {
+	if len(s.Sources) == 0 && len(s.Programs) == 0 && s.Sniff == nil {
+		return true
+	}
+	if l.Program != "" && slices.Contains(s.Programs, l.Program) {
+		return true
+	}
+	source := l.Source
+	base := path.Base(source)
+	for _, pattern := range s.Sources {
+		name := base
+		if strings.Contains(pattern, "/") {
+			name = source
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return s.Sniff != nil && s.Sniff(msg)
+}
+
+// Registry holds the parsers of the server. Lines no parser recognizes
+// become "generic" events.
+type Registry struct {
+	mu      sync.RWMutex
+	parsers []registered
+}
+
+type registered struct {
+	Parser
+	sel Selector
+}
+
+// Register adds p; a parser with the same name is replaced.
+func (r *Registry) Register(p Parser, sel Selector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.parsers = slices.DeleteFunc(r.parsers, func(e registered) bool { return e.Name() == p.Name() })
+	r.parsers = append(r.parsers, registered{Parser: p, sel: sel})
+	slices.SortStableFunc(r.parsers, func(a, b registered) int { return b.sel.Priority - a.sel.Priority })
+}
+
+// Names returns the registered parsers in the order they are tried.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, len(r.parsers))
+	for i, p := range r.parsers {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// Parse normalizes a line read from source: its syslog header, if any,
+// and then the message with the first selected parser that recognizes it.
+// Level comes from the syslog severity, else it is guessed from the text.
+func (r *Registry) Parse(source, line string) NormalizedLog {
+	l := NormalizedLog{
+		Source:  source,
+		Message: strings.TrimSpace(line),
+		Raw:     line,
+	}
+	msg, ok := parseSyslog(line, &l)
+	if !ok {
+		msg = line
+	}
+	if l.Severity == "" {
+		l.Level = parseLevel(line)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.parsers {
+		if p.sel.selects(&l, msg) && p.Parse(msg, &l) {
+			return l
+		}
+	}
+	l.EventType = "generic"
+	return l
+}
@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// <PRI>, which is optional in files written by syslog daemons.
+	priRe = regexp.MustCompile(`^<(\d{1,3})>`)
+	// Dec 13 10:01:02 host sshd[123]: msg
+	rfc3164HeaderRe = regexp.MustCompile(`^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}(?:\.\d+)? (\S+) ([^\s\[\]:]+)(?:\[(\d+)\])?: ?`)
+	// The same with the ISO 8601 timestamps of rsyslog's high-precision
+	// format, which many applications also start their lines with.
+	isoHeaderRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\S+ (\S+) ([^\s\[\]:]+)(?:\[(\d+)\])?: ?`)
+	// A host name or address; all capitals is rather a level like INFO.
+	hostnameRe = regexp.MustCompile(`^(?:[A-Za-z0-9][A-Za-z0-9.-]*|[0-9A-Fa-f:.]+)$`)
+	levelRe    = regexp.MustCompile(`^[A-Z]+$`)
+	// sshd[123]: msg, as sent to /dev/log without timestamp and host.
+	tagRe = regexp.MustCompile(`^([^\s\[\]:]+)(?:\[(\d+)\])?: ?`)
+	// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	rfc5424HeaderRe = regexp.MustCompile(`^<\d{1,3}>1 \S+ (\S+) (\S+) (\S+) (\S+) `)
+)
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// severityLevels maps syslog severities to Level.
+var severityLevels = []string{"error", "error", "error", "error", "warn", "info", "info", "debug"}
+
+// parseSyslog fills the fields of l that the syslog header of line
+// names: priority, host name, program and PID, and for RFC 5424 the
+// message ID and structured data. Level is set from the severity. It
+// returns the message after the header, or false if line has none.
+func parseSyslog(line string, l *NormalizedLog) (string, bool) {
+	if m := rfc5424HeaderRe.FindStringSubmatch(line); m != nil {
+		sd, msg, ok := parseStructuredData(line[len(m[0]):])
+		if !ok {
+			return "", false
+		}
+		setPriority(line, l)
+		l.Hostname = nilValue(m[1])
+		l.Program = nilValue(m[2])
+		if pid, err := strconv.Atoi(m[3]); err == nil {
+			l.Pid = pid
+		}
+		if id := nilValue(m[4]); id != "" {
+			setField(l, "msgid", id)
+		}
+		l.StructuredData = sd
+		msg = strings.TrimPrefix(strings.TrimPrefix(msg, " "), "\ufeff")
+		return msg, true
+	}
+
+	pri := priRe.FindString(line)
+	rest := line[len(pri):]
+	m := rfc3164HeaderRe.FindStringSubmatch(rest)
+	if m == nil {
+		// Without a <PRI> the ISO variant must name a plausible host, so
+		// application logs that start with a timestamp and a level aren't
+		// taken for syslog.
+		if m = isoHeaderRe.FindStringSubmatch(rest); m != nil && pri == "" && !plausibleHost(m[1]) {
+			m = nil
+		}
+	}
+	if m != nil {
+		l.Hostname = m[1]
+		l.Program = m[2]
+		l.Pid, _ = strconv.Atoi(m[3])
+	} else if m = tagRe.FindStringSubmatch(rest); m != nil && pri != "" {
+		l.Program = m[1]
+		l.Pid, _ = strconv.Atoi(m[2])
+	} else {
+		return "", false
+	}
+	setPriority(line, l)
+	return rest[len(m[0]):], true
+}
+
+func plausibleHost(s string) bool {
+	return hostnameRe.MatchString(s) && !levelRe.MatchString(s)
+}
+
+func setPriority(line string, l *NormalizedLog) {
+	m := priRe.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	pri, _ := strconv.Atoi(m[1])
+	if pri >= len(facilities)*8 {
+		return
+	}
+	l.Facility = facilities[pri/8]
+	l.Severity = severities[pri%8]
+	l.Level = severityLevels[pri%8]
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseStructuredData parses the RFC 5424 STRUCTURED-DATA at the start
+// of s, "-" or [id name="value" ...] elements, and returns the rest of s.
+func parseStructuredData(s string) (map[string]map[string]string, string, bool) {
+	if rest, ok := strings.CutPrefix(s, "-"); ok && (rest == "" || rest[0] == ' ') {
+		return nil, rest, true
+	}
+	sd := map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 0 {
+			return nil, "", false
+		}
+		params := map[string]string{}
+		sd[s[1:end]] = params
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			name, rest, ok := strings.Cut(s[1:], `="`)
+			if !ok {
+				return nil, "", false
+			}
+			value, n, ok := sdValue(rest)
+			if !ok {
+				return nil, "", false
+			}
+			params[name] = value
+			s = rest[n:]
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", false
+		}
+		s = s[1:]
+	}
+	if len(sd) == 0 || (s != "" && s[0] != ' ') {
+		return nil, "", false
+	}
+	return sd, s, true
+}
+
+// sdValue unescapes a PARAM-VALUE up to its closing quote and returns
+// the length consumed.
+func sdValue(s string) (string, int, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return b.String(), i + 1, true
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0:
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
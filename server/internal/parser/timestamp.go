@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// <PRI>VERSION TIMESTAMP: RFC 5424 syslog, "-" when unknown.
+	rfc5424TimeRe = regexp.MustCompile(`^<\d{1,3}>\d{1,2} (\S+)`)
+	// 2024-01-02T03:04:05.123+01:00, also with a space or a comma.
+	isoTimeRe = regexp.MustCompile(`^(?:<\d{1,3}>)?(\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)(Z|[+-]\d{2}:?\d{2})?`)
+	// Dec 13 10:01:02: RFC 3164 syslog, without a year.
+	rfc3164TimeRe = regexp.MustCompile(`^(?:<\d{1,3}>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}(?:\.\d+)?)`)
+	// 2024/01/02 03:04:05: nginx error log.
+	nginxErrorTimeRe = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
+	// [Wed Oct 11 14:32:52.123456 2000]: Apache error log.
+	apacheErrorTimeRe = regexp.MustCompile(`^\[[A-Z][a-z]{2} ([A-Z][a-z]{2} \d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? \d{4})\]`)
+	// msg=audit(1700000000.123:4567): auditd, Unix time.
+	auditTimeRe = regexp.MustCompile(`msg=audit\((\d+)\.(\d+):\d+\)`)
+	// [10/Oct/2000:13:55:36 -0700]: common and combined access logs.
+	accessTimeRe = regexp.MustCompile(`\[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`)
+)
+
+// EventTime extracts the time a log line was written from its syslog
+// (RFC 3164 or 5424), ISO 8601, nginx, Apache or auditd timestamp. Times without
+// a zone are taken to be in loc. RFC 3164 times have no year: they get
+// the year that puts them closest before ref, allowing ref to be a day
+// behind the sender's clock; Feb 29 in a year that has none is no time.
+// The result is in UTC.
+func EventTime(line string, ref time.Time, loc *time.Location) (time.Time, bool) {
+	if m := rfc5424TimeRe.FindStringSubmatch(line); m != nil {
+		t, err := time.Parse(time.RFC3339Nano, m[1])
+		return t.UTC(), err == nil
+	}
+	if m := isoTimeRe.FindStringSubmatch(line); m != nil {
+		return isoTime(m[1], m[2], m[3], loc)
+	}
+	if m := rfc3164TimeRe.FindStringSubmatch(line); m != nil {
+		p, err := time.ParseInLocation("Jan _2 15:04:05", m[1], loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+		ref = ref.In(loc)
+		in := func(year int) time.Time {
+			return time.Date(year, p.Month(), p.Day(), p.Hour(), p.Minute(), p.Second(), p.Nanosecond(), loc)
+		}
+		t := in(ref.Year())
+		if t.After(ref.Add(24 * time.Hour)) {
+			t = in(ref.Year() - 1)
+		}
+		if t.Day() != p.Day() {
+			return time.Time{}, false
+		}
+		return t.UTC(), true
+	}
+	if m := nginxErrorTimeRe.FindStringSubmatch(line); m != nil {
+		return parseIn("2006/01/02 15:04:05", m[1], loc)
+	}
+	if m := apacheErrorTimeRe.FindStringSubmatch(line); m != nil {
+		return parseIn("Jan 02 15:04:05 2006", m[1], loc)
+	}
+	if m := auditTimeRe.FindStringSubmatch(line); m != nil {
+		sec, _ := strconv.ParseInt(m[1], 10, 64)
+		frac, _ := strconv.ParseFloat("0."+m[2], 64)
+		return time.Unix(sec, int64(frac*1e9)).UTC(), true
+	}
+	if m := accessTimeRe.FindStringSubmatch(line); m != nil {
+		return parseIn("02/Jan/2006:15:04:05 -0700", m[1], loc)
+	}
+	return time.Time{}, false
+}
+
+func isoTime(date, clock, zone string, loc *time.Location) (time.Time, bool) {
+	s := date + "T" + strings.Replace(clock, ",", ".", 1)
+	if zone == "" {
+		return parseIn("2006-01-02T15:04:05", s, loc)
+	}
+	if zone != "Z" && !strings.Contains(zone, ":") {
+		zone = zone[:3] + ":" + zone[3:]
+	}
+	return parseIn(time.RFC3339Nano, s+zone, loc)
+}
+
+func parseIn(layout, value string, loc *time.Location) (time.Time, bool) {
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// 1.2.3.4 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://ref/" "Mozilla/4.08"
+	// Common and combined log format, optionally followed by nginx's
+	// $request_time as "0.123" or "rt=0.123".
+	accessRe = regexp.MustCompile(`^(\S+) \S+ (\S+) \[[^\]]+\] "([^"\\]*(?:\\.[^"\\]*)*)" (\d{3}) (\d+|-)(?: "([^"\\]*(?:\\.[^"\\]*)*)" "([^"\\]*(?:\\.[^"\\]*)*)")?(?: (?:rt=|request_time=)?(\d+(?:\.\d+)?))?`)
+	// 2024/01/02 03:04:05 [error] 1234#0: *5 message, client: 1.2.3.4, server: x, request: "GET / HTTP/1.1"
+	nginxErrorRe = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} \[(\w+)\] (\d+)#\d+: (?:\*\d+ )?(.*)$`)
+	// The start of an nginx error line up to its pid#tid.
+	nginxErrorSniffRe = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} .* \d+#\d+:`)
+	// [Wed Oct 11 14:32:52.123 2000] [core:error] [pid 1234:tid 5] [client 1.2.3.4:5678] message
+	// [Wed Oct 11 14:32:52 2000] [error] [client 1.2.3.4] message
+	apacheErrorRe = regexp.MustCompile(`^\[[A-Z][a-z]{2} [A-Z][a-z]{2} \d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? \d{4}\] \[(?:[\w-]+:)?(\w+)\](?: \[pid (\d+)(?::tid \d+)?\])?(?: \[client ([^\]]+)\])? (.*)$`)
+
+	nginxClientRe  = regexp.MustCompile(`, client: ([^,]+)`)
+	nginxRequestRe = regexp.MustCompile(`, request: "([^"]*)"`)
+)
+
+// accessLogParser reads common and combined access logs of nginx and
+// Apache into web_access events. Request values go into the method, path,
+// protocol, status, bytes, referrer, user_agent and request_time fields.
+type accessLogParser struct{}
+
+func (accessLogParser) Name() string { return "access_log" }
+
+func (accessLogParser) Parse(line string, l *NormalizedLog) bool {
+	m := accessRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	l.EventType = "web_access"
+	setClient(l, m[1], "")
+	if m[2] != "-" {
+		l.User = m[2]
+	}
+	setRequest(l, m[3])
+	setField(l, "status", m[4])
+	if m[5] != "-" {
+		setField(l, "bytes", m[5])
+	}
+	if m[6] != "" && m[6] != "-" {
+		setField(l, "referrer", m[6])
+	}
+	if m[7] != "" && m[7] != "-" {
+		setField(l, "user_agent", m[7])
+	}
+	if m[8] != "" {
+		setField(l, "request_time", m[8])
+	}
+	status, _ := strconv.Atoi(m[4])
+	switch {
+	case status >= 500:
+		l.Level = "error"
+	case status >= 400:
+		l.Level = "warn"
+	default:
+		l.Level = "info"
+	}
+	return true
+}
+
+// webErrorLogParser reads nginx and Apache error logs into web_error
+// events, with the client and request of the error when they are given.
+type webErrorLogParser struct{}
+
+func (webErrorLogParser) Name() string { return "web_error_log" }
+
+func (webErrorLogParser) Parse(line string, l *NormalizedLog) bool {
+	var level, pid, client, request string
+	if m := nginxErrorRe.FindStringSubmatch(line); m != nil {
+		level, pid = m[1], m[2]
+		if c := nginxClientRe.FindStringSubmatch(m[3]); c != nil {
+			client = c[1]
+		}
+		if r := nginxRequestRe.FindStringSubmatch(m[3]); r != nil {
+			request = r[1]
+		}
+	} else if m := apacheErrorRe.FindStringSubmatch(line); m != nil {
+		level, pid, client = m[1], m[2], m[3]
+	} else {
+		return false
+	}
+
+	l.EventType = "web_error"
+	l.Level = webErrorLevel(level)
+	l.Pid, _ = strconv.Atoi(pid)
+	if client != "" {
+		setClient(l, client, "")
+	}
+	if request != "" {
+		setRequest(l, request)
+	}
+	return true
+}
+
+func webErrorLevel(level string) string {
+	switch level {
+	case "emerg", "alert", "crit", "error":
+		return "error"
+	case "warn":
+		return "warn"
+	case "debug":
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// setRequest splits a request line like "GET /index.html HTTP/1.1".
+func setRequest(l *NormalizedLog, request string) {
+	method, rest, ok := strings.Cut(request, " ")
+	if !ok {
+		return
+	}
+	setField(l, "method", method)
+	target, proto, _ := strings.Cut(rest, " ")
+	setField(l, "path", target)
+	if proto != "" {
+		setField(l, "protocol", proto)
+	}
+}
+
+func sniffAccessLog(line string) bool {
+	return strings.Contains(line, `] "`) && strings.Contains(line, `" `)
+}
+
+func sniffWebErrorLog(line string) bool {
+	return apacheErrorTimeRe.MatchString(line) || nginxErrorSniffRe.MatchString(line)
+}
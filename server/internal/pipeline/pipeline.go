@@ -0,0 +1,108 @@
+// Package pipeline runs jobs through a fixed sequence of stages on a
+// bounded number of workers.
+//
+// Jobs are spread over shards by key. Every shard runs each stage in its
+// own goroutine, connected by bounded queues, so jobs of one key pass
+// every stage in submission order while different stages work on
+// consecutive jobs at the same time. When a shard's queues are full,
+// Submit blocks, which pushes back on whoever reads the input.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+)
+
+const defaultQueue = 64
+
+// Stage is one step of a job. A stage error skips the remaining stages.
+type Stage[T any] struct {
+	Name string
+	Run  func(T) error
+}
+
+type Config[T any] struct {
+	Workers int        // shards; defaults to the number of CPUs
+	Queue   int        // capacity of each stage queue; defaults to 64
+	Stages  []Stage[T] // at least one
+	// Done is called with every job after its last stage, or after the
+	// stage that failed, in submission order per key.
+	Done func(job T, err error)
+}
+
+type Pipeline[T any] struct {
+	cfg    Config[T]
+	shards []chan item[T]
+	queues []chan item[T]
+}
+
+type item[T any] struct {
+	job T
+	err error
+}
+
+func New[T any](cfg Config[T]) *Pipeline[T] {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = defaultQueue
+	}
+	p := &Pipeline[T]{cfg: cfg}
+	for range cfg.Workers {
+		in := make(chan item[T], cfg.Queue)
+		p.shards = append(p.shards, in)
+		p.queues = append(p.queues, in)
+		for i, st := range cfg.Stages {
+			var out chan item[T]
+			if i < len(cfg.Stages)-1 {
+				out = make(chan item[T], cfg.Queue)
+				p.queues = append(p.queues, out)
+			}
+			go p.run(st, in, out)
+			in = out
+		}
+	}
+	return p
+}
+
+func (p *Pipeline[T]) run(st Stage[T], in <-chan item[T], out chan<- item[T]) {
+	for it := range in {
+		if it.err == nil {
+			if err := st.Run(it.job); err != nil {
+				it.err = fmt.Errorf("%s: %w", st.Name, err)
+			}
+		}
+		if out == nil {
+			if p.cfg.Done != nil {
+				p.cfg.Done(it.job, it.err)
+			}
+			continue
+		}
+		out <- it
+	}
+}
+
+// Submit queues job on the shard of key. It blocks while the shard is
+// full, until ctx is done.
+func (p *Pipeline[T]) Submit(ctx context.Context, key string, job T) error {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	select {
+	case p.shards[h.Sum32()%uint32(len(p.shards))] <- item[T]{job: job}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Queued returns the number of jobs waiting in queues.
+func (p *Pipeline[T]) Queued() int {
+	n := 0
+	for _, q := range p.queues {
+		n += len(q)
+	}
+	return n
+}
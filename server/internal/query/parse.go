@@ -0,0 +1,312 @@
+package query
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	word kind = iota
+	phrase
+	lparen
+	rparen
+)
+
+type token struct {
+	kind kind
+	text string // words keep their \ escapes; phrases are unescaped
+	pos  int
+}
+
+func (t token) String() string {
+	return strconv.Quote(t.text)
+}
+
+type parser struct {
+	src    string
+	toks   []token
+	pos    int
+	schema Schema
+}
+
+// Parse parses src. Fields not known to s are syntax errors.
+func Parse(src string, s Schema) (*Query, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, &SyntaxError{Offset: 0, Msg: "empty query"}
+	}
+	p := &parser{src: src, toks: toks, schema: s}
+	e, err := p.or("")
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+	return &Query{Root: e, src: src}, nil
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: lparen, text: "(", pos: i})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: rparen, text: ")", pos: i})
+			i++
+		case c == '"':
+			var b strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, &SyntaxError{Offset: start, Msg: "unterminated phrase"}
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				} else if s[i] == '"' {
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			toks = append(toks, token{kind: phrase, text: b.String(), pos: start})
+			i++
+		default:
+			start := i
+		loop:
+			for ; i < len(s); i++ {
+				switch s[i] {
+				case '\\':
+					if i+1 == len(s) {
+						return nil, &SyntaxError{Offset: i, Msg: "trailing backslash"}
+					}
+					i++
+				case ' ', '\t', '\n', '\r', '(', ')', '"':
+					break loop
+				}
+			}
+			toks = append(toks, token{kind: word, text: s[start:i], pos: start})
+		}
+	}
+	return toks, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *parser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && t.kind == word && t.text == kw {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) error {
+	return &SyntaxError{Offset: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// field is the field of an enclosing field:(...) group, or "".
+func (p *parser) or(field string) (Expr, error) {
+	e, err := p.and(field)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		y, err := p.and(field)
+		if err != nil {
+			return nil, err
+		}
+		e = &Or{X: e, Y: y}
+	}
+	return e, nil
+}
+
+func (p *parser) and(field string) (Expr, error) {
+	e, err := p.not(field)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if !p.keyword("AND") {
+			// Adjacent terms are ANDed too.
+			t, ok := p.peek()
+			if !ok || t.kind == rparen || t.kind == word && t.text == "OR" {
+				return e, nil
+			}
+		}
+		y, err := p.not(field)
+		if err != nil {
+			return nil, err
+		}
+		e = &And{X: e, Y: y}
+	}
+}
+
+func (p *parser) not(field string) (Expr, error) {
+	if p.keyword("NOT") {
+		e, err := p.not(field)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: e}, nil
+	}
+	return p.primary(field)
+}
+
+func (p *parser) primary(field string) (Expr, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, p.errorf(len(p.src), "unexpected end of query")
+	}
+	p.pos++
+
+	switch t.kind {
+	case lparen:
+		return p.group(t, field)
+	case rparen:
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	case phrase:
+		if field == "" {
+			return p.term(p.schema.Text, Glob, "*"+escapeGlob(t.text)+"*", t.pos)
+		}
+		return p.term(field, Eq, t.text, t.pos)
+	}
+
+	if t.text == "AND" || t.text == "OR" {
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+	name, value, ok := splitField(t.text)
+	if !ok {
+		if field == "" {
+			return p.term(p.schema.Text, Glob, "*"+t.text+"*", t.pos)
+		}
+		return p.value(field, t.text, t.pos)
+	}
+	if name == "" {
+		return nil, p.errorf(t.pos, "missing field name before \":\"")
+	}
+	if !p.schema.Known(name) {
+		return nil, p.errorf(t.pos, "unknown field %q", name)
+	}
+	if value != "" {
+		return p.value(name, value, t.pos+len(t.text)-len(value))
+	}
+
+	// field:(...) or field:"phrase"
+	next, ok := p.peek()
+	switch {
+	case ok && next.kind == lparen && next.pos == t.pos+len(t.text):
+		p.pos++
+		return p.group(next, name)
+	case ok && next.kind == phrase && next.pos == t.pos+len(t.text):
+		p.pos++
+		return p.term(name, Eq, next.text, next.pos)
+	}
+	return nil, p.errorf(t.pos+len(t.text), "expected a value after %q", t.text)
+}
+
+func (p *parser) group(open token, field string) (Expr, error) {
+	e, err := p.or(field)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); !ok || t.kind != rparen {
+		return nil, p.errorf(open.pos, "unclosed parenthesis")
+	}
+	p.pos++
+	return e, nil
+}
+
+// value parses the value of a field term; raw keeps its \ escapes.
+func (p *parser) value(field, raw string, pos int) (Expr, error) {
+	for _, c := range []struct {
+		prefix string
+		op     Op
+	}{{">=", Gte}, {"<=", Lte}, {">", Gt}, {"<", Lt}} {
+		if rest, ok := strings.CutPrefix(raw, c.prefix); ok {
+			n, err := strconv.ParseFloat(unescape(rest), 64)
+			if err != nil {
+				return nil, p.errorf(pos+len(c.prefix), "expected a number after %s", c.prefix)
+			}
+			return &Term{Field: field, Op: c.op, Value: unescape(rest), Num: n, IsNum: true, Pos: pos}, nil
+		}
+	}
+
+	if hasWildcard(raw) {
+		return p.term(field, Glob, raw, pos)
+	}
+	lit := unescape(raw)
+	if strings.Contains(lit, "/") {
+		if _, n, err := net.ParseCIDR(lit); err == nil {
+			return &Term{Field: field, Op: CIDR, Value: lit, Net: n, Pos: pos}, nil
+		}
+	}
+	return p.term(field, Eq, lit, pos)
+}
+
+func (p *parser) term(field string, op Op, value string, pos int) (Expr, error) {
+	t := &Term{Field: field, Op: op, Value: value, Pos: pos}
+	switch op {
+	case Glob:
+		t.re = compileGlob(value)
+	case Eq:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			t.Num, t.IsNum = n, true
+		}
+	}
+	return t, nil
+}
+
+// splitField splits a word at its first unescaped colon.
+func splitField(s string) (name, value string, ok bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ':':
+			return unescape(s[:i]), s[i+1:], true
+		}
+	}
+	return "", s, false
+}
+
+func hasWildcard(s string) bool {
+	for _, c := range globParts(s) {
+		if c.wild {
+			return true
+		}
+	}
+	return false
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for _, c := range globParts(s) {
+		b.WriteRune(c.r)
+	}
+	return b.String()
+}
+
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
@@ -0,0 +1,190 @@
+// Package query implements the search language of the log and alert
+// APIs:
+//
+//	event_type:ssh_failed AND src_ip:10.0.0.0/8 AND NOT user:deploy
+//	user:(root OR admin*) pid:>=1000 "session opened"
+//
+// A term is field:value or a bare word or "quoted phrase", which is
+// searched in the schema's text field. Values may use * and ? wildcards,
+// >, >=, < and <= comparisons of numbers, or CIDR ranges. Terms without
+// an operator between them are ANDed; NOT binds tighter than AND, which
+// binds tighter than OR. Matching is case-insensitive.
+package query
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Doc is a document a query runs against.
+type Doc interface {
+	Field(name string) (string, bool)
+}
+
+// Schema describes the documents of a query.
+type Schema struct {
+	Text  string                 // field searched by bare words and phrases
+	Known func(name string) bool // fields that may be used in terms
+}
+
+// Query is a parsed query.
+type Query struct {
+	Root Expr
+	src  string
+}
+
+func (q *Query) String() string { return q.src }
+
+// Match reports whether d matches q.
+func (q *Query) Match(d Doc) bool {
+	return q.Root.match(d)
+}
+
+// Expr is a node of a query: *And, *Or, *Not or *Term.
+type Expr interface {
+	match(d Doc) bool
+}
+
+type And struct{ X, Y Expr }
+
+type Or struct{ X, Y Expr }
+
+type Not struct{ X Expr }
+
+func (e *And) match(d Doc) bool { return e.X.match(d) && e.Y.match(d) }
+func (e *Or) match(d Doc) bool  { return e.X.match(d) || e.Y.match(d) }
+func (e *Not) match(d Doc) bool { return !e.X.match(d) }
+
+type Op int
+
+const (
+	Eq   Op = iota // equal; numbers are compared as numbers
+	Glob           // Value is a pattern with * and ?, see Like
+	Lt
+	Lte
+	Gt
+	Gte
+	CIDR
+)
+
+// Term compares one field. Documents without the field never match.
+type Term struct {
+	Field string
+	Op    Op
+	Value string
+	Num   float64    // for Lt, Lte, Gt, Gte and numeric Eq values
+	IsNum bool       // Value is a number
+	Net   *net.IPNet // for CIDR
+	Pos   int        // byte offset in the query
+
+	re *regexp.Regexp
+}
+
+func (t *Term) match(d Doc) bool {
+	v, ok := d.Field(t.Field)
+	if !ok {
+		return false
+	}
+	switch t.Op {
+	case Eq:
+		if t.IsNum {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return n == t.Num
+			}
+		}
+		return strings.EqualFold(v, t.Value)
+	case Glob:
+		return t.re.MatchString(v)
+	case CIDR:
+		ip := net.ParseIP(v)
+		return ip != nil && t.Net.Contains(ip)
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	switch t.Op {
+	case Lt:
+		return n < t.Num
+	case Lte:
+		return n <= t.Num
+	case Gt:
+		return n > t.Num
+	default:
+		return n >= t.Num
+	}
+}
+
+// Like returns the Glob pattern of t as a SQL LIKE pattern with \ as
+// escape character.
+func (t *Term) Like() string {
+	var b strings.Builder
+	for _, c := range globParts(t.Value) {
+		switch {
+		case c.wild && c.r == '*':
+			b.WriteByte('%')
+		case c.wild:
+			b.WriteByte('_')
+		case c.r == '%' || c.r == '_' || c.r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c.r)
+		default:
+			b.WriteRune(c.r)
+		}
+	}
+	return b.String()
+}
+
+func compileGlob(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, c := range globParts(pattern) {
+		switch {
+		case c.wild && c.r == '*':
+			b.WriteString(".*")
+		case c.wild:
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c.r)))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.MustCompile(b.String())
+}
+
+type globChar struct {
+	r    rune
+	wild bool
+}
+
+// globParts splits a pattern into runes, resolving \ escapes.
+func globParts(pattern string) []globChar {
+	var out []globChar
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			out = append(out, globChar{r: r})
+			escaped = false
+		case r == '\\':
+			escaped = true
+		default:
+			out = append(out, globChar{r: r, wild: r == '*' || r == '?'})
+		}
+	}
+	return out
+}
+
+// SyntaxError reports an invalid query.
+type SyntaxError struct {
+	Offset int // byte offset of the error in the query
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query: %s at offset %d", e.Msg, e.Offset)
+}
@@ -0,0 +1,122 @@
+package query
+
+import (
+	"errors"
+	"testing"
+)
+
+type doc map[string]string
+
+func (d doc) Field(name string) (string, bool) {
+	v, ok := d[name]
+	return v, ok
+}
+
+var testSchema = Schema{
+	Text: "msg",
+	Known: func(name string) bool {
+		switch name {
+		case "msg", "host", "user", "src_ip", "pid", "event_type", "path":
+			return true
+		}
+		return false
+	},
+}
+
+func TestMatch(t *testing.T) {
+	d := doc{
+		"msg":        `Accepted password for root from 10.1.2.3 port 22 "ssh2"`,
+		"user":       "Root",
+		"src_ip":     "10.1.2.3",
+		"pid":        "1042",
+		"event_type": "ssh_success",
+		"path":       "/var/www/50%_off",
+	}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"user:root", true},
+		{"user:admin", false},
+		{"user:(admin OR ro*)", true},
+		{"user:r?ot", true},
+		{"user:ro", false},
+		{"src_ip:10.0.0.0/8", true},
+		{"src_ip:192.168.0.0/16", false},
+		{"pid:>=1042 pid:<2000", true},
+		{"pid:>1042", false},
+		{"pid:1042.0", true},
+		{"password", true},
+		{`"password for root"`, true},
+		{`"password root"`, false},
+		{`\"ssh2\"`, true},
+		{"NOT user:root", false},
+		// Documents without the field don't match the term.
+		{"host:*", false},
+		{"NOT host:x", true},
+		// NOT binds tighter than AND, which binds tighter than OR.
+		{"user:admin AND pid:1 OR event_type:ssh_success", true},
+		{"user:admin AND (pid:1 OR event_type:ssh_success)", false},
+		{"NOT user:admin event_type:ssh_success", true},
+		{"user:admin OR NOT pid:1042 OR src_ip:10.1.2.3", true},
+		{`path:/var/www/50%_off`, true},
+		{`path:\*`, false},
+		{`user:"root"`, true},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query, testSchema)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if got := q.Match(d); got != tt.want {
+			t.Errorf("%q matched %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"nope:x", 0},
+		{"user:x AND", 10},
+		{"(user:x", 0},
+		{"user:x)", 6},
+		{`"open`, 0},
+		{"user:", 5},
+		{":x", 0},
+		{"pid:>abc", 5},
+		{`user:x\`, 6},
+		{"OR user:x", 0},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query, testSchema)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("Parse(%q) = %v, want a SyntaxError", tt.query, err)
+			continue
+		}
+		if se.Offset != tt.offset {
+			t.Errorf("Parse(%q): offset %d, want %d (%v)", tt.query, se.Offset, tt.offset, err)
+		}
+	}
+}
+
+func TestLike(t *testing.T) {
+	tests := map[string]string{
+		`ro*`:      `ro%`,
+		`r?ot`:     `r_ot`,
+		`50%_*`:    `50\%\_%`,
+		`a\*b*`:    `a*b%`,
+		`c:\\win*`: `c:\\win%`,
+	}
+	for pattern, want := range tests {
+		if got := (&Term{Value: pattern}).Like(); got != want {
+			t.Errorf("Like(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package rules
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// Anomaly turns a rule into an anomaly rule: it learns a baseline of every
+// series and fires when an interval deviates from it. A series is one of
+// Fields of one GroupBy group, averaged over each Interval of event time;
+// without Fields it is the number of matching events per Interval.
+//
+//	anomaly:
+//	  fields: [metrics.cpu, metrics.mem]
+//	  group_by: [host]
+//	  interval: 5m
+//	  method: mad
+//	  threshold: 3.5
+//
+// Method zscore (the default) scores an interval by its distance from the
+// mean in standard deviations, mad by its distance from the median in
+// scaled median absolute deviations, which outliers in the baseline hardly
+// move. The baseline is the last History intervals of the same hour of day
+// unless Seasonality is none. No alerts fire before a series has been seen
+// for Warmup and its baseline holds MinSamples intervals.
+type Anomaly struct {
+	Fields    []string      `yaml:"fields,omitempty"`
+	GroupBy   []string      `yaml:"group_by"`
+	Interval  time.Duration `yaml:"interval"`
+	Method    string        `yaml:"method"`
+	Threshold float64       `yaml:"threshold"`
+	// Direction is up (the default), down or both.
+	Direction string `yaml:"direction"`
+	// MinDeviation is how far an interval must be from the baseline at
+	// least, in the unit of the series, so near-constant series don't
+	// fire on tiny changes.
+	MinDeviation float64       `yaml:"min_deviation,omitempty"`
+	Seasonality  string        `yaml:"seasonality"`
+	History      int           `yaml:"history,omitempty"`
+	Warmup       time.Duration `yaml:"warmup"`
+	MinSamples   int           `yaml:"min_samples,omitempty"`
+	MaxGroups    int           `yaml:"max_groups,omitempty"`
+}
+
+const (
+	defaultAnomalyInterval = 5 * time.Minute
+	defaultAnomalyHistory  = 36
+	defaultMinSamples      = 5
+	// defaultMaxSeries bounds the series tracked per anomaly rule when it
+	// doesn't set max_groups; a series keeps up to 24 × history values.
+	defaultMaxSeries = 10_000
+	// anomalyGrace is how long after its end Sweep closes an interval,
+	// for events still on their way.
+	anomalyGrace = time.Minute
+)
+
+// Anomaly methods and directions.
+const (
+	MethodZScore = "zscore"
+	MethodMAD    = "mad"
+
+	DirectionUp   = "up"
+	DirectionDown = "down"
+	DirectionBoth = "both"
+)
+
+type compiledAnomaly struct {
+	Anomaly
+	seasons int // 24 for hour of day, 1 without seasonality
+}
+
+func compileAnomaly(id string, a Anomaly) (*compiledAnomaly, error) {
+	for _, f := range append(slices.Clone(a.Fields), a.GroupBy...) {
+		if !parser.KnownField(f) {
+			return nil, fmt.Errorf("rule %s: anomaly: unknown field %q", id, f)
+		}
+	}
+	if a.Interval < 0 || a.Warmup < 0 || a.Threshold < 0 || a.MinDeviation < 0 || a.History < 0 || a.MinSamples < 0 || a.MaxGroups < 0 {
+		return nil, fmt.Errorf("rule %s: anomaly: interval, warmup, threshold, min_deviation, history, min_samples and max_groups must not be negative", id)
+	}
+	if a.Interval == 0 {
+		a.Interval = defaultAnomalyInterval
+	}
+	switch a.Method {
+	case "", MethodZScore:
+		a.Method = MethodZScore
+		if a.Threshold == 0 {
+			a.Threshold = 3
+		}
+	case MethodMAD:
+		if a.Threshold == 0 {
+			a.Threshold = 3.5
+		}
+	default:
+		return nil, fmt.Errorf("rule %s: anomaly: unknown method %q", id, a.Method)
+	}
+	switch a.Direction {
+	case "":
+		a.Direction = DirectionUp
+	case DirectionUp, DirectionDown, DirectionBoth:
+	default:
+		return nil, fmt.Errorf("rule %s: anomaly: unknown direction %q", id, a.Direction)
+	}
+
+	c := &compiledAnomaly{}
+	switch a.Seasonality {
+	case "", "hour_of_day":
+		a.Seasonality = "hour_of_day"
+		c.seasons = 24
+		if a.Interval > time.Hour {
+			return nil, fmt.Errorf("rule %s: anomaly: interval can't exceed an hour with hour_of_day seasonality", id)
+		}
+	case "none":
+		c.seasons = 1
+	default:
+		return nil, fmt.Errorf("rule %s: anomaly: unknown seasonality %q", id, a.Seasonality)
+	}
+	if a.History == 0 {
+		a.History = defaultAnomalyHistory
+	}
+	if a.MinSamples == 0 {
+		a.MinSamples = defaultMinSamples
+	}
+	if a.MinSamples > a.History {
+		return nil, fmt.Errorf("rule %s: anomaly: min_samples exceeds history", id)
+	}
+	if a.Warmup == 0 && c.seasons > 1 {
+		// Every hour of the day once.
+		a.Warmup = 24 * time.Hour
+	}
+	if a.MaxGroups == 0 {
+		a.MaxGroups = defaultMaxSeries
+	}
+	c.Anomaly = a
+	return c, nil
+}
+
+// ttl is how long an idle series keeps its baseline: the time its history
+// spans, but at least a day.
+func (a *compiledAnomaly) ttl() time.Duration {
+	return max(a.Interval*time.Duration(a.History*a.seasons), 24*time.Hour)
+}
+
+func (a *compiledAnomaly) season(start time.Time) int {
+	if a.seasons == 1 {
+		return 0
+	}
+	return start.UTC().Hour()
+}
+
+// anomalySeries is the baseline and the open interval of one series.
+type anomalySeries struct {
+	first   time.Time // start of the first interval seen
+	start   time.Time // start of the open interval, or of the last closed one when n is 0
+	sum     float64
+	n       int
+	seasons []history
+	log     parser.NormalizedLog // the last log added, for alerts of Sweep
+}
+
+// history is a ring of the last observations of one season.
+type history struct {
+	values []float64
+	next   int
+}
+
+func (h *history) add(v float64, limit int) {
+	if len(h.values) < limit {
+		h.values = append(h.values, v)
+		return
+	}
+	h.values[h.next] = v
+	h.next = (h.next + 1) % limit
+}
+
+// anomalyFinding is an interval that deviated from its baseline.
+type anomalyFinding struct {
+	field    string
+	start    time.Time
+	value    float64
+	count    int // events in the interval
+	expected float64
+	spread   float64
+	score    float64
+	samples  int
+}
+
+// observe adds log to the open interval of its series, one per anomaly
+// field, and returns the intervals it closed that were anomalous. Logs
+// older than the open interval or in an interval Sweep closed are ignored;
+// logs from the future count as now.
+func (r *RuleEngine) observe(rule *compiledRule, log parser.NormalizedLog) []anomalyFinding {
+	a := rule.anomaly
+	key, ok := groupKey(a.GroupBy, log)
+	if !ok {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	ts := log.Timestamp
+	if ts.IsZero() || ts.After(now) {
+		ts = now
+	}
+	start := ts.Truncate(a.Interval)
+
+	fields := a.Fields
+	if len(fields) == 0 {
+		fields = []string{""}
+	}
+	var out []anomalyFinding
+	for _, field := range fields {
+		v := 1.0
+		if field != "" {
+			s, ok := log.Field(field)
+			if !ok {
+				continue
+			}
+			var err error
+			if v, err = strconv.ParseFloat(s, 64); err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+		}
+		s := r.anomalies[rule.ID].get(key+"\x00"+field, now)
+		s.log = log
+		if f, fire := s.add(a, start, v); fire {
+			f.field = field
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// add counts v in the interval starting at start, closing the open
+// interval first when start is later.
+func (s *anomalySeries) add(a *compiledAnomaly, start time.Time, v float64) (anomalyFinding, bool) {
+	if s.seasons == nil {
+		s.seasons = make([]history, a.seasons)
+		s.first, s.start, s.sum, s.n = start, start, v, 1
+		return anomalyFinding{}, false
+	}
+	if start.Before(s.start) || start.Equal(s.start) && s.n == 0 {
+		return anomalyFinding{}, false
+	}
+	if start.Equal(s.start) {
+		s.sum += v
+		s.n++
+		return anomalyFinding{}, false
+	}
+
+	var f anomalyFinding
+	var fire bool
+	if s.n > 0 {
+		f, fire = s.close(a)
+	}
+	if len(a.Fields) == 0 {
+		// No events is a rate of zero; a full history of them is enough.
+		gaps := int(start.Sub(s.start)/a.Interval) - 1
+		for i := min(gaps, a.History*a.seasons); i > 0; i-- {
+			t := start.Add(-time.Duration(i) * a.Interval)
+			s.seasons[a.season(t)].add(0, a.History)
+		}
+	}
+	s.start, s.sum, s.n = start, v, 1
+	return f, fire
+}
+
+// expire closes the open interval once it is over by anomalyGrace at now
+// and, for event counts, scores the intervals without events after it
+// that are over too.
+func (s *anomalySeries) expire(a *compiledAnomaly, now time.Time) []anomalyFinding {
+	if s.seasons == nil {
+		return nil
+	}
+	// Intervals that start no later than last are over.
+	last := now.Add(-anomalyGrace - a.Interval)
+	if s.start.After(last) {
+		return nil
+	}
+	var out []anomalyFinding
+	if s.n > 0 {
+		if f, fire := s.close(a); fire {
+			out = append(out, f)
+		}
+		s.sum, s.n = 0, 0
+	}
+	if len(a.Fields) > 0 {
+		return out
+	}
+	// No events is a rate of zero; a full history of them is enough.
+	if gaps, limit := int(last.Sub(s.start)/a.Interval), a.History*a.seasons; gaps > limit {
+		s.start = s.start.Add(time.Duration(gaps-limit) * a.Interval)
+	}
+	for t := s.start.Add(a.Interval); !t.After(last); t = t.Add(a.Interval) {
+		s.start = t
+		if f, fire := s.close(a); fire {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// close scores the open interval against the baseline of its season and
+// then adds it to the baseline.
+func (s *anomalySeries) close(a *compiledAnomaly) (anomalyFinding, bool) {
+	v := s.sum
+	if len(a.Fields) > 0 {
+		v /= float64(s.n)
+	}
+	h := &s.seasons[a.season(s.start)]
+	defer h.add(v, a.History)
+
+	if s.start.Sub(s.first) < a.Warmup || len(h.values) < a.MinSamples {
+		return anomalyFinding{}, false
+	}
+	expected, spread := baseline(a.Method, h.values)
+	if len(a.Fields) == 0 {
+		// Event counts vary at least like a Poisson process.
+		spread = max(spread, math.Sqrt(max(expected, 1)))
+	}
+	var score float64
+	switch d := v - expected; {
+	case d == 0:
+	case spread == 0:
+		score = math.Copysign(math.Inf(1), d)
+	default:
+		score = d / spread
+	}
+
+	var fire bool
+	switch a.Direction {
+	case DirectionUp:
+		fire = score >= a.Threshold
+	case DirectionDown:
+		fire = score <= -a.Threshold
+	default:
+		fire = math.Abs(score) >= a.Threshold
+	}
+	if !fire || math.Abs(v-expected) < a.MinDeviation {
+		return anomalyFinding{}, false
+	}
+	return anomalyFinding{
+		start:    s.start,
+		value:    v,
+		count:    s.n,
+		expected: expected,
+		spread:   spread,
+		score:    score,
+		samples:  len(h.values),
+	}, true
+}
+
+// SweepResult is an alert of Sweep with the last log of its series.
+type SweepResult struct {
+	Log    parser.NormalizedLog
+	Result RuleResult
+}
+
+// Sweep closes the anomaly intervals that ended more than a minute before
+// now, which a series that goes quiet gets no later event for, and
+// returns the alerts of the anomalous ones. For event counts, the
+// intervals without events since are scored as well, so direction down
+// fires when a series stops.
+func (r *RuleEngine) Sweep(now time.Time) []SweepResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []SweepResult
+	for _, rule := range r.rules {
+		if rule.anomaly == nil {
+			continue
+		}
+		r.anomalies[rule.ID].each(now, func(key string, s *anomalySeries) {
+			for _, f := range s.expire(rule.anomaly, now) {
+				f.field = key[strings.LastIndexByte(key, 0)+1:]
+				out = append(out, SweepResult{Log: s.log, Result: rule.anomalyResult(s.log, f)})
+			}
+		})
+	}
+	return out
+}
+
+// baseline returns the mean and standard deviation of values, or for
+// MethodMAD their median and median absolute deviation scaled to match a
+// standard deviation for normal data. When more than half of the values
+// are equal the MAD is 0 and the scaled mean absolute deviation from the
+// median takes its place.
+func baseline(method string, values []float64) (center, spread float64) {
+	n := float64(len(values))
+	if method == MethodZScore {
+		for _, v := range values {
+			center += v
+		}
+		center /= n
+		if len(values) < 2 {
+			return center, 0
+		}
+		for _, v := range values {
+			spread += (v - center) * (v - center)
+		}
+		return center, math.Sqrt(spread / (n - 1))
+	}
+
+	center = median(slices.Clone(values))
+	dev := make([]float64, len(values))
+	var sum float64
+	for i, v := range values {
+		dev[i] = math.Abs(v - center)
+		sum += dev[i]
+	}
+	if mad := median(dev); mad > 0 {
+		return center, 1.4826 * mad
+	}
+	return center, 1.2533 * sum / n
+}
+
+// median sorts values.
+func median(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// anomalyResult builds the alert of finding; the message can use it as
+// .anomaly, e.g. {{.anomaly.value}}.
+func (c *compiledRule) anomalyResult(log parser.NormalizedLog, f anomalyFinding) RuleResult {
+	res := c.result(log, f.count, nil, map[string]any{
+		"anomaly": map[string]any{
+			"field":     f.field,
+			"interval":  f.start.UTC(),
+			"value":     f.value,
+			"expected":  f.expected,
+			"spread":    f.spread,
+			"score":     f.score,
+			"method":    c.anomaly.Method,
+			"threshold": c.anomaly.Threshold,
+			"samples":   f.samples,
+		},
+	})
+	if res.DedupKey != "" && f.field != "" {
+		res.DedupKey += "|anomaly=" + f.field
+	}
+	return res
+}
@@ -0,0 +1,391 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// Rule is a detection as written in a rule file.
+//
+//	id: SSH_BRUTEFORCE
+//	severity: HIGH
+//	message: "SSH bruteforce {{.src_ip}}: {{.count}} attempts"
+//	match:
+//	  all:
+//	    - field: event_type
+//	      equals: ssh_failed
+//	threshold:
+//	  count: 5
+//	  window: 5m
+//	  group_by: [src_ip]
+type Rule struct {
+	ID          string  `yaml:"id"`
+	Title       string  `yaml:"title"`
+	Description string  `yaml:"description"`
+	Enabled     *bool   `yaml:"enabled"`
+	Severity    string  `yaml:"severity"`
+	Score       float64 `yaml:"score"`
+	// ScorePerEvent is added to Score for every event counted by the
+	// threshold or sequence, so scores grow with the size of an attack.
+	ScorePerEvent float64    `yaml:"score_per_event"`
+	Message       string     `yaml:"message"`
+	Mitre         []string   `yaml:"mitre"`
+	Match         Match      `yaml:"match"`
+	Threshold     *Threshold `yaml:"threshold"`
+	Sequence      *Sequence  `yaml:"sequence"`
+	Anomaly       *Anomaly   `yaml:"anomaly"`
+	Dedup         *Dedup     `yaml:"dedup"`
+}
+
+// Match combines conditions: every condition in All, at least one in Any
+// (when Any is not empty) and none in None must hold.
+type Match struct {
+	All  []Condition `yaml:"all,omitempty"`
+	Any  []Condition `yaml:"any,omitempty"`
+	None []Condition `yaml:"none,omitempty"`
+}
+
+// Condition tests one NormalizedLog field, named by its JSON name or as
+// "fields.<name>". Exactly one operator must be set. A condition without
+// a field is a group that combines nested conditions like Match does.
+type Condition struct {
+	Field      string   `yaml:"field,omitempty"`
+	Equals     *string  `yaml:"equals,omitempty"`
+	Contains   string   `yaml:"contains,omitempty"`
+	Regex      string   `yaml:"regex,omitempty"`
+	In         []string `yaml:"in,omitempty"`
+	CIDR       []string `yaml:"cidr,omitempty"`
+	Gt         *float64 `yaml:"gt,omitempty"`
+	Gte        *float64 `yaml:"gte,omitempty"`
+	Lt         *float64 `yaml:"lt,omitempty"`
+	Lte        *float64 `yaml:"lte,omitempty"`
+	IgnoreCase bool     `yaml:"ignore_case,omitempty"`
+
+	All  []Condition `yaml:"all,omitempty"`
+	Any  []Condition `yaml:"any,omitempty"`
+	None []Condition `yaml:"none,omitempty"`
+}
+
+// Threshold turns a rule into a counting rule: it fires once Count
+// matching events with the same GroupBy values fall within a sliding
+// Window of event time. MaxGroups caps the groups tracked at once.
+type Threshold struct {
+	Count     int           `yaml:"count"`
+	Window    time.Duration `yaml:"window"`
+	GroupBy   []string      `yaml:"group_by"`
+	MaxGroups int           `yaml:"max_groups,omitempty"`
+}
+
+// Dedup folds repeated hits of a rule with the same Key values into one
+// open alert as long as they come within Window of the previous hit. Key
+// defaults to the threshold or anomaly group_by or sequence by fields, or
+// to host; Window defaults to an hour.
+type Dedup struct {
+	Key      []string      `yaml:"key"`
+	Window   time.Duration `yaml:"window"`
+	Disabled bool          `yaml:"disabled"`
+}
+
+const defaultDedupWindow = time.Hour
+
+var severities = map[string]bool{"LOW": true, "MEDIUM": true, "HIGH": true, "CRITICAL": true}
+
+type compiledRule struct {
+	Rule
+	match   matcher
+	stages  []compiledStage
+	anomaly *compiledAnomaly
+	message *template.Template
+
+	dedupKey    []string
+	dedupWindow time.Duration // 0 disables dedup
+}
+
+type matcher func(l parser.NormalizedLog) bool
+
+func compile(r Rule) (*compiledRule, error) {
+	if r.ID == "" {
+		return nil, fmt.Errorf("missing id")
+	}
+	r.Severity = strings.ToUpper(r.Severity)
+	if !severities[r.Severity] {
+		return nil, fmt.Errorf("rule %s: invalid severity %q", r.ID, r.Severity)
+	}
+	// A sequence rule's match only prefilters logs for its stages.
+	if r.Sequence == nil && len(r.Match.All)+len(r.Match.Any)+len(r.Match.None) == 0 {
+		return nil, fmt.Errorf("rule %s: no match conditions", r.ID)
+	}
+	kinds := 0
+	for _, set := range []bool{r.Threshold != nil, r.Sequence != nil, r.Anomaly != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return nil, fmt.Errorf("rule %s: threshold, sequence and anomaly can't be combined", r.ID)
+	}
+	if t := r.Threshold; t != nil {
+		if t.Count < 1 {
+			return nil, fmt.Errorf("rule %s: threshold count must be positive", r.ID)
+		}
+		if t.Window <= 0 {
+			return nil, fmt.Errorf("rule %s: threshold window must be positive", r.ID)
+		}
+		if t.MaxGroups < 0 {
+			return nil, fmt.Errorf("rule %s: threshold max_groups must not be negative", r.ID)
+		}
+		for _, f := range t.GroupBy {
+			if !parser.KnownField(f) {
+				return nil, fmt.Errorf("rule %s: unknown group_by field %q", r.ID, f)
+			}
+		}
+	}
+
+	if d := r.Dedup; d != nil {
+		if d.Window < 0 {
+			return nil, fmt.Errorf("rule %s: dedup window must not be negative", r.ID)
+		}
+		for _, f := range d.Key {
+			if !parser.KnownField(f) {
+				return nil, fmt.Errorf("rule %s: unknown dedup key field %q", r.ID, f)
+			}
+		}
+	}
+
+	c := &compiledRule{Rule: r}
+	var err error
+	c.match, err = compileGroup(r.Match.All, r.Match.Any, r.Match.None)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+	}
+	if r.Sequence != nil {
+		if c.stages, err = compileSequence(r.ID, r.Sequence); err != nil {
+			return nil, err
+		}
+	}
+	if r.Anomaly != nil {
+		if c.anomaly, err = compileAnomaly(r.ID, *r.Anomaly); err != nil {
+			return nil, err
+		}
+	}
+
+	c.dedupKey, c.dedupWindow = dedup(r)
+
+	msg := r.Message
+	if msg == "" {
+		msg = r.ID
+	}
+	c.message, err = template.New(r.ID).Option("missingkey=zero").Parse(msg)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: message: %w", r.ID, err)
+	}
+	return c, nil
+}
+
+func dedup(r Rule) ([]string, time.Duration) {
+	d := Dedup{}
+	if r.Dedup != nil {
+		d = *r.Dedup
+	}
+	if d.Disabled {
+		return nil, 0
+	}
+	if d.Window == 0 {
+		d.Window = defaultDedupWindow
+	}
+	switch {
+	case len(d.Key) > 0:
+	case r.Threshold != nil && len(r.Threshold.GroupBy) > 0:
+		d.Key = r.Threshold.GroupBy
+	case r.Anomaly != nil && len(r.Anomaly.GroupBy) > 0:
+		d.Key = r.Anomaly.GroupBy
+	case r.Sequence != nil:
+		d.Key = r.Sequence.By
+	default:
+		d.Key = []string{"host"}
+	}
+	return d.Key, d.Window
+}
+
+// Validate reports whether r would be accepted by NewRuleEngine.
+func (r Rule) Validate() error {
+	_, err := compile(r)
+	return err
+}
+
+func compileGroup(all, any, none []Condition) (matcher, error) {
+	var err error
+	var allM, anyM, noneM []matcher
+	if allM, err = compileConditions("all", all); err != nil {
+		return nil, err
+	}
+	if anyM, err = compileConditions("any", any); err != nil {
+		return nil, err
+	}
+	if noneM, err = compileConditions("none", none); err != nil {
+		return nil, err
+	}
+
+	return func(l parser.NormalizedLog) bool {
+		for _, m := range allM {
+			if !m(l) {
+				return false
+			}
+		}
+		if len(anyM) > 0 {
+			found := false
+			for _, m := range anyM {
+				if m(l) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		for _, m := range noneM {
+			if m(l) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileConditions(kind string, conds []Condition) ([]matcher, error) {
+	out := make([]matcher, 0, len(conds))
+	for i, cond := range conds {
+		m, err := compileCondition(cond)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", kind, i, err)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func compileCondition(c Condition) (matcher, error) {
+	if c.Field == "" {
+		if len(c.All)+len(c.Any)+len(c.None) == 0 {
+			return nil, fmt.Errorf("condition needs a field or nested all/any/none")
+		}
+		return compileGroup(c.All, c.Any, c.None)
+	}
+	if len(c.All)+len(c.Any)+len(c.None) > 0 {
+		return nil, fmt.Errorf("field %s: nested conditions need a condition without field", c.Field)
+	}
+	if !parser.KnownField(c.Field) {
+		return nil, fmt.Errorf("unknown field %q", c.Field)
+	}
+
+	ops := 0
+	for _, set := range []bool{
+		c.Equals != nil, c.Contains != "", c.Regex != "", len(c.In) > 0, len(c.CIDR) > 0,
+		c.Gt != nil, c.Gte != nil, c.Lt != nil, c.Lte != nil,
+	} {
+		if set {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return nil, fmt.Errorf("field %s: exactly one operator required, got %d", c.Field, ops)
+	}
+
+	field := c.Field
+	get := func(l parser.NormalizedLog) (string, bool) { return l.Field(field) }
+	fold := func(s string) string { return s }
+	if c.IgnoreCase {
+		fold = strings.ToLower
+	}
+
+	switch {
+	case c.Equals != nil:
+		want := fold(*c.Equals)
+		return func(l parser.NormalizedLog) bool {
+			v, ok := get(l)
+			return ok && fold(v) == want
+		}, nil
+	case c.Contains != "":
+		want := fold(c.Contains)
+		return func(l parser.NormalizedLog) bool {
+			v, ok := get(l)
+			return ok && strings.Contains(fold(v), want)
+		}, nil
+	case c.Regex != "":
+		expr := c.Regex
+		if c.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", c.Field, err)
+		}
+		return func(l parser.NormalizedLog) bool {
+			v, ok := get(l)
+			return ok && re.MatchString(v)
+		}, nil
+	case len(c.In) > 0:
+		set := make(map[string]bool, len(c.In))
+		for _, v := range c.In {
+			set[fold(v)] = true
+		}
+		return func(l parser.NormalizedLog) bool {
+			v, ok := get(l)
+			return ok && set[fold(v)]
+		}, nil
+	case len(c.CIDR) > 0:
+		nets := make([]*net.IPNet, 0, len(c.CIDR))
+		for _, s := range c.CIDR {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", c.Field, err)
+			}
+			nets = append(nets, n)
+		}
+		return func(l parser.NormalizedLog) bool {
+			v, _ := get(l)
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return false
+			}
+			for _, n := range nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	default:
+		var cmp func(float64) bool
+		switch {
+		case c.Gt != nil:
+			cmp = func(v float64) bool { return v > *c.Gt }
+		case c.Gte != nil:
+			cmp = func(v float64) bool { return v >= *c.Gte }
+		case c.Lt != nil:
+			cmp = func(v float64) bool { return v < *c.Lt }
+		default:
+			cmp = func(v float64) bool { return v <= *c.Lte }
+		}
+		return func(l parser.NormalizedLog) bool {
+			v, ok := get(l)
+			if !ok {
+				return false
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			return err == nil && cmp(f)
+		}, nil
+	}
+}
+
+func (c *compiledRule) matches(l parser.NormalizedLog) bool {
+	return c.match(l)
+}
@@ -0,0 +1,122 @@
+package rules
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed builtin/*.yaml
+var builtin embed.FS
+
+// Load returns the built-in rules followed by the rules in dir. A rule in
+// dir replaces the built-in rule with the same id, so built-ins can be
+// tuned or disabled (enabled: false) without a rebuild. An empty dir
+// loads only the built-ins.
+func Load(dir string) ([]Rule, error) {
+	rules, err := loadFS(builtin, "builtin")
+	if err != nil {
+		return nil, fmt.Errorf("builtin rules: %w", err)
+	}
+	if dir == "" {
+		return rules, nil
+	}
+
+	custom, err := loadFS(os.DirFS(dir), ".")
+	if err != nil {
+		return nil, fmt.Errorf("rules dir %s: %w", dir, err)
+	}
+
+	index := make(map[string]int, len(rules))
+	for i, r := range rules {
+		index[r.ID] = i
+	}
+	for _, r := range custom {
+		if i, ok := index[r.ID]; ok {
+			rules[i] = r
+			continue
+		}
+		index[r.ID] = len(rules)
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func loadFS(fsys fs.FS, dir string) ([]Rule, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var rules []Rule
+	seen := make(map[string]string)
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, r := range parsed {
+			if prev, ok := seen[r.ID]; ok {
+				return nil, fmt.Errorf("%s: duplicate rule id %s (first in %s)", name, r.ID, prev)
+			}
+			seen[r.ID] = name
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// Parse decodes and validates one rule file, which may hold several
+// rules as separate YAML documents.
+func Parse(data []byte) ([]Rule, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var rules []Rule
+	for {
+		var r Rule
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.ID = strings.TrimSpace(r.ID)
+		if r.ID == "" {
+			return nil, fmt.Errorf("rule without id")
+		}
+		// A disabled rule only needs its id, so that a built-in can be
+		// switched off with a two-line file.
+		if r.Enabled == nil || *r.Enabled {
+			if err := r.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -1,114 +1,218 @@
 package rules
 
 import (
+	"bytes"
 	"fmt"
+	"maps"
 	"math"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/siem/internal/parser"
 )
 
 type RuleResult struct {
-	Type     string  `json:"type"`
-	Severity string  `json:"severity"`
-	Score    float64 `json:"score"`
-	Message  string  `json:"message"`
+	Type     string   `json:"type"`
+	Title    string   `json:"title,omitempty"`
+	Severity string   `json:"severity"`
+	Score    float64  `json:"score"`
+	Message  string   `json:"message"`
+	Mitre    []string `json:"mitre,omitempty"`
+	// Logs holds every log that contributed to a sequence rule, oldest
+	// first.
+	Logs []parser.NormalizedLog `json:"logs,omitempty"`
+	// Results with the same DedupKey within DedupWindow belong to one
+	// alert. An empty key disables dedup.
+	DedupKey    string        `json:"dedup_key,omitempty"`
+	DedupWindow time.Duration `json:"-"`
 }
 
+// RuleEngine evaluates declarative rules against normalized logs. It is
+// safe for concurrent use.
 type RuleEngine struct {
-	failedLogins map[string]int // IP → count
-	lastReset    time.Time
+	rules []*compiledRule
+
+	mu        sync.Mutex
+	windows   map[string]*slidingWindow         // rule id → group counts
+	sequences map[string]*groups[sequenceState] // rule id → progress per join key
+	anomalies map[string]*groups[anomalySeries] // rule id → baseline per series
 }
 
-func NewRuleEngine() *RuleEngine {
-	return &RuleEngine{
-		failedLogins: make(map[string]int),
-		lastReset:    time.Now(),
+func NewRuleEngine(defs []Rule) (*RuleEngine, error) {
+	r := &RuleEngine{
+		windows:   make(map[string]*slidingWindow),
+		sequences: make(map[string]*groups[sequenceState]),
+		anomalies: make(map[string]*groups[anomalySeries]),
+	}
+	for _, def := range defs {
+		if def.Enabled != nil && !*def.Enabled {
+			continue
+		}
+		c, err := compile(def)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(r.rules, func(o *compiledRule) bool { return o.ID == c.ID }) {
+			return nil, fmt.Errorf("duplicate rule id %s", c.ID)
+		}
+		r.rules = append(r.rules, c)
+		if c.Threshold != nil {
+			r.windows[c.ID] = newSlidingWindow(c.Threshold)
+		}
+		if c.Sequence != nil {
+			r.sequences[c.ID] = newGroups[sequenceState](c.Sequence.MaxSpan, c.Sequence.MaxGroups)
+		}
+		if c.anomaly != nil {
+			r.anomalies[c.ID] = newGroups[anomalySeries](c.anomaly.ttl(), c.anomaly.MaxGroups)
+		}
 	}
+	return r, nil
 }
 
-func (r *RuleEngine) Check(log parser.NormalizedLog) []RuleResult {
-	r.resetSlidingWindow()
+// Rules returns the enabled rules.
+func (r *RuleEngine) Rules() []Rule {
+	out := make([]Rule, len(r.rules))
+	for i, c := range r.rules {
+		out[i] = c.Rule
+	}
+	return out
+}
 
+func (r *RuleEngine) Check(log parser.NormalizedLog) []RuleResult {
 	var results []RuleResult
+	for _, rule := range r.rules {
+		if !rule.matches(log) {
+			continue
+		}
 
-	switch log.EventType {
-	case "ssh_failed":
-		results = append(results, r.checkSSHBruteforce(log)...)
-	case "ssh_success":
-		results = append(results, r.checkSuspiciousSuccess(log)...)
-	case "sudo":
-		results = append(results, r.checkSudoAbuse(log)...)
-	case "metrics":
-		results = append(results, r.checkResourceExhaustion(log)...)
-	}
+		if rule.Sequence != nil {
+			if logs, fire := r.advance(rule, log); fire {
+				results = append(results, rule.result(log, len(logs), logs, nil))
+			}
+			continue
+		}
+		if rule.anomaly != nil {
+			for _, f := range r.observe(rule, log) {
+				results = append(results, rule.anomalyResult(log, f))
+			}
+			continue
+		}
 
+		count := 1
+		if rule.Threshold != nil {
+			var fire bool
+			if count, fire = r.count(rule, log); !fire {
+				continue
+			}
+		}
+		results = append(results, rule.result(log, count, nil, nil))
+	}
 	return results
 }
 
-func (r *RuleEngine) checkSSHBruteforce(log parser.NormalizedLog) []RuleResult {
-	if log.SrcIP == "" {
-		return nil
-	}
+// ActiveGroups returns the number of groups currently tracked by
+// threshold and sequence rules and of series tracked by anomaly rules.
+func (r *RuleEngine) ActiveGroups() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	r.failedLogins[log.SrcIP]++
+	n := 0
+	now := time.Now()
+	for _, w := range r.windows {
+		n += w.size(now)
+	}
+	for _, s := range r.sequences {
+		n += s.size(now)
+	}
+	for _, a := range r.anomalies {
+		n += a.size(now)
+	}
+	return n
+}
 
-	count := r.failedLogins[log.SrcIP]
-	if count >= 5 {
-		return []RuleResult{{
-			Type:     "SSH_BRUTEFORCE",
-			Severity: "HIGH",
-			Score:    float64(count) / 5.0,
-			Message:  fmt.Sprintf("SSH bruteforce from %s: %d attempts", log.SrcIP, count),
-		}}
+// count adds log to its group and reports whether the threshold is met.
+// Groups with an empty group_by field are not counted. Logs without a
+// timestamp count at the current time.
+func (r *RuleEngine) count(rule *compiledRule, log parser.NormalizedLog) (int, bool) {
+	key, ok := groupKey(rule.Threshold.GroupBy, log)
+	if !ok {
+		return 0, false
 	}
 
-	return nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	ts := log.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+	n := r.windows[rule.ID].add(key, ts, now)
+	return n, n >= rule.Threshold.Count
 }
 
-func (r *RuleEngine) checkSuspiciousSuccess(log parser.NormalizedLog) []RuleResult {
-	suspiciousUsers := []string{"root", "admin", "test", "ubuntu", "pi"}
-	for _, user := range suspiciousUsers {
-		if strings.Contains(strings.ToLower(log.User), user) {
-			return []RuleResult{{
-				Type:     "SUSPICIOUS_LOGIN",
-				Severity: "MEDIUM",
-				Score:    0.8,
-				Message:  fmt.Sprintf("Suspicious user login: %s from %s", log.User, log.SrcIP),
-			}}
+func groupKey(fields []string, log parser.NormalizedLog) (string, bool) {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		v, _ := log.Field(f)
+		if v == "" {
+			return "", false
 		}
+		parts[i] = v
 	}
-	return nil
+	return strings.Join(parts, "\x00"), true
 }
 
-func (r *RuleEngine) checkSudoAbuse(log parser.NormalizedLog) []RuleResult {
-	if log.User != "root" && strings.Contains(log.Message, "COMMAND=/bin/sh") {
-		return []RuleResult{{
-			Type:     "SUDO_PRIVESC",
-			Severity: "HIGH",
-			Score:    0.95,
-			Message:  fmt.Sprintf("Potential sudo priv esc by %s", log.User),
-		}}
+// result builds the alert for log. For sequence rules, logs are all
+// contributing logs and the message can refer to the first one as .first.
+// extra adds values for the message.
+func (c *compiledRule) result(log parser.NormalizedLog, count int, logs []parser.NormalizedLog, extra map[string]any) RuleResult {
+	data := log.Values()
+	maps.Copy(data, extra)
+	data["count"] = count
+	data["rule"] = c.ID
+	if len(logs) > 0 {
+		data["first"] = logs[0].Values()
 	}
-	return nil
-}
 
-func (r *RuleEngine) checkResourceExhaustion(log parser.NormalizedLog) []RuleResult {
-	if cpu, mem := extractMetrics(log.Message); cpu > 90 || mem > 90 {
-		return []RuleResult{{
-			Type:     "RESOURCE_EXHAUSTION",
-			Severity: "MEDIUM",
-			Score:    math.Max(cpu, mem) / 100.0,
-			Message:  fmt.Sprintf("High resource usage: CPU %.1f%% MEM %.1f%%", cpu, mem),
-		}}
+	var msg bytes.Buffer
+	if err := c.message.Execute(&msg, data); err != nil {
+		msg.Reset()
+		fmt.Fprintf(&msg, "%s (message template: %v)", c.ID, err)
+	}
+
+	score := c.Score
+	if c.Threshold != nil || c.Sequence != nil {
+		score += c.ScorePerEvent * float64(count)
+	}
+	score = math.Round(score*100) / 100
+
+	res := RuleResult{
+		Type:     c.ID,
+		Title:    c.Title,
+		Severity: c.Severity,
+		Score:    score,
+		Message:  msg.String(),
+		Mitre:    c.Mitre,
+		Logs:     logs,
+	}
+	if c.dedupWindow > 0 {
+		res.DedupKey = c.dedupKeyOf(log)
+		res.DedupWindow = c.dedupWindow
 	}
-	return nil
+	return res
 }
 
-func (r *RuleEngine) resetSlidingWindow() {
-	if time.Since(r.lastReset) > 5*time.Minute {
-		r.failedLogins = make(map[string]int)
-		r.lastReset = time.Now()
+// dedupKeyOf renders the dedup key readably, e.g.
+// "SSH_BRUTEFORCE|src_ip=1.2.3.4".
+func (c *compiledRule) dedupKeyOf(log parser.NormalizedLog) string {
+	var b strings.Builder
+	b.WriteString(c.ID)
+	for _, f := range c.dedupKey {
+		v, _ := log.Field(f)
+		fmt.Fprintf(&b, "|%s=%s", f, v)
 	}
+	return b.String()
 }
@@ -0,0 +1,222 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// newEngine compiles the rules of a rule file.
+func newEngine(t *testing.T, yaml string) *RuleEngine {
+	t.Helper()
+	defs, err := Parse([]byte(yaml))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	e, err := NewRuleEngine(defs)
+	if err != nil {
+		t.Fatalf("NewRuleEngine: %v", err)
+	}
+	return e
+}
+
+// fired returns the rule ids of the results of log.
+func fired(e *RuleEngine, log parser.NormalizedLog) []string {
+	var ids []string
+	for _, r := range e.Check(log) {
+		ids = append(ids, r.Type)
+	}
+	return ids
+}
+
+func TestBuiltinRulesCompile(t *testing.T) {
+	defs, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := NewRuleEngine(defs); err != nil {
+		t.Fatalf("NewRuleEngine: %v", err)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	e := newEngine(t, `
+id: EQUALS
+severity: low
+match:
+  all:
+    - {field: event_type, equals: SSH_FAILED, ignore_case: true}
+---
+id: REGEX_NONE
+severity: low
+match:
+  all:
+    - {field: msg, regex: 'COMMAND=/bin/sh$'}
+  none:
+    - {field: user, in: [root, admin]}
+---
+id: CIDR_ANY
+severity: low
+match:
+  any:
+    - {field: src_ip, cidr: [10.0.0.0/8]}
+    - all:
+        - {field: src_ip, contains: "192.168."}
+        - {field: fields.src_port, gte: 1024}
+---
+id: METRIC_GT
+severity: low
+match:
+  all:
+    - {field: metrics.cpu, gt: 90}
+`)
+	tests := []struct {
+		name string
+		log  parser.NormalizedLog
+		want []string
+	}{
+		{"equals ignoring case", parser.NormalizedLog{EventType: "ssh_failed"}, []string{"EQUALS"}},
+		{"regex", parser.NormalizedLog{User: "alice", Message: "sudo: alice : COMMAND=/bin/sh"}, []string{"REGEX_NONE"}},
+		{"none excludes", parser.NormalizedLog{User: "root", Message: "sudo: root : COMMAND=/bin/sh"}, nil},
+		{"cidr", parser.NormalizedLog{SrcIP: "10.1.2.3"}, []string{"CIDR_ANY"}},
+		{"nested all", parser.NormalizedLog{SrcIP: "192.168.1.5", Fields: map[string]string{"src_port": "50000"}}, []string{"CIDR_ANY"}},
+		{"nested all fails", parser.NormalizedLog{SrcIP: "192.168.1.5", Fields: map[string]string{"src_port": "22"}}, nil},
+		{"not an address", parser.NormalizedLog{SrcIP: "host.example"}, nil},
+		{"gt", parser.NormalizedLog{Metrics: map[string]float64{"cpu": 95}}, []string{"METRIC_GT"}},
+		{"gt boundary", parser.NormalizedLog{Metrics: map[string]float64{"cpu": 90}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fired(e, tt.log)
+			if len(got) != len(tt.want) || len(got) > 0 && got[0] != tt.want[0] {
+				t.Errorf("fired %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	for name, yaml := range map[string]string{
+		"unknown field":   "id: R\nseverity: low\nmatch: {all: [{field: nope, equals: x}]}",
+		"two operators":   "id: R\nseverity: low\nmatch: {all: [{field: user, equals: x, contains: y}]}",
+		"bad severity":    "id: R\nseverity: urgent\nmatch: {all: [{field: user, equals: x}]}",
+		"no conditions":   "id: R\nseverity: low",
+		"bad regex":       "id: R\nseverity: low\nmatch: {all: [{field: user, regex: '('}]}",
+		"zero count":      "id: R\nseverity: low\nmatch: {all: [{field: user, equals: x}]}\nthreshold: {count: 0, window: 1m}",
+		"unknown key":     "id: R\nseverity: low\nmatch: {all: [{field: user, equals: x}]}\nthreshhold: {count: 1}",
+		"threshold+anoma": "id: R\nseverity: low\nmatch: {all: [{field: user, equals: x}]}\nthreshold: {count: 1, window: 1m}\nanomaly: {}",
+	} {
+		if _, err := Parse([]byte(yaml)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	e := newEngine(t, `
+id: BRUTE
+severity: high
+message: "{{.src_ip}}: {{.count}} failures"
+match:
+  all: [{field: event_type, equals: ssh_failed}]
+threshold:
+  count: 3
+  window: 1m
+  group_by: [src_ip]
+`)
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	failure := func(ip string, at time.Duration) parser.NormalizedLog {
+		return parser.NormalizedLog{EventType: "ssh_failed", SrcIP: ip, Timestamp: start.Add(at)}
+	}
+
+	for i, at := range []time.Duration{0, 10 * time.Second} {
+		if got := e.Check(failure("1.2.3.4", at)); len(got) != 0 {
+			t.Fatalf("failure %d fired %v", i, got)
+		}
+	}
+	// Another address counts on its own.
+	if got := e.Check(failure("5.6.7.8", 20*time.Second)); len(got) != 0 {
+		t.Fatalf("other address fired %v", got)
+	}
+	got := e.Check(failure("1.2.3.4", 30*time.Second))
+	if len(got) != 1 {
+		t.Fatalf("third failure fired %d results, want 1", len(got))
+	}
+	if got[0].Message != "1.2.3.4: 3 failures" {
+		t.Errorf("message = %q", got[0].Message)
+	}
+	if got[0].DedupKey != "BRUTE|src_ip=1.2.3.4" || got[0].DedupWindow != time.Hour {
+		t.Errorf("dedup = %q %v", got[0].DedupKey, got[0].DedupWindow)
+	}
+	// The earlier failures left the window.
+	if got := e.Check(failure("1.2.3.4", 95*time.Second)); len(got) != 0 {
+		t.Errorf("failure after the window fired %v", got)
+	}
+}
+
+func TestLoadOverridesBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"off.yaml":    "id: SSH_BRUTEFORCE\nenabled: false\n",
+		"custom.yaml": "id: CUSTOM\nseverity: low\nmatch: {all: [{field: event_type, equals: x}]}\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defs, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	e, err := NewRuleEngine(defs)
+	if err != nil {
+		t.Fatalf("NewRuleEngine: %v", err)
+	}
+	ids := map[string]bool{}
+	for _, r := range e.Rules() {
+		ids[r.ID] = true
+	}
+	if ids["SSH_BRUTEFORCE"] || !ids["CUSTOM"] || !ids["SSH_BRUTEFORCE_SUCCESS"] {
+		t.Errorf("rules = %v", ids)
+	}
+}
+
+func TestDedupKeys(t *testing.T) {
+	e := newEngine(t, `
+id: DEFAULT
+severity: low
+match: {all: [{field: event_type, equals: x}]}
+---
+id: KEYED
+severity: low
+match: {all: [{field: event_type, equals: x}]}
+dedup: {key: [user, fields.path], window: 10m}
+---
+id: OFF
+severity: low
+match: {all: [{field: event_type, equals: x}]}
+dedup: {disabled: true}
+`)
+	log := parser.NormalizedLog{EventType: "x", Host: "web1", User: "alice", Fields: map[string]string{"path": "/etc/passwd"}}
+	want := map[string]struct {
+		key    string
+		window time.Duration
+	}{
+		"DEFAULT": {"DEFAULT|host=web1", time.Hour},
+		"KEYED":   {"KEYED|user=alice|fields.path=/etc/passwd", 10 * time.Minute},
+		"OFF":     {"", 0},
+	}
+	results := e.Check(log)
+	if len(results) != len(want) {
+		t.Fatalf("fired %d results, want %d", len(results), len(want))
+	}
+	for _, r := range results {
+		if w := want[r.Type]; r.DedupKey != w.key || r.DedupWindow != w.window {
+			t.Errorf("%s: dedup %q %v, want %q %v", r.Type, r.DedupKey, r.DedupWindow, w.key, w.window)
+		}
+	}
+}
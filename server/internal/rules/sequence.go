@@ -0,0 +1,216 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// Sequence turns a rule into a correlation rule: it fires when logs
+// matching each stage arrive in order, with the same join values, within
+// MaxSpan of event time.
+//
+//	sequence:
+//	  by: [src_ip]
+//	  max_span: 10m
+//	  stages:
+//	    - match: {all: [{field: event_type, equals: ssh_failed}]}
+//	      count: 5
+//	    - match: {all: [{field: event_type, equals: ssh_success}]}
+type Sequence struct {
+	By        []string      `yaml:"by"`
+	MaxSpan   time.Duration `yaml:"max_span"`
+	MaxGroups int           `yaml:"max_groups,omitempty"`
+	Stages    []Stage       `yaml:"stages"`
+}
+
+// Stage is one step of a sequence. Count logs (default 1) must match it
+// before the next stage starts. By replaces the sequence's join fields
+// for this stage, e.g. to join a src_ip against a dst_ip; it must have
+// as many fields.
+type Stage struct {
+	Match Match    `yaml:"match"`
+	Count int      `yaml:"count,omitempty"`
+	By    []string `yaml:"by,omitempty"`
+}
+
+type compiledStage struct {
+	match matcher
+	count int
+	by    []string
+}
+
+// sequenceState is the progress of one join key.
+type sequenceState struct {
+	stage int // stage waiting for logs
+	count int // logs matched by that stage so far
+	// head holds the logs of the first stage within MaxSpan, oldest
+	// first. Once it is complete, its first anchored logs start the later
+	// stages; the others arrived after those progressed and can only
+	// start over.
+	head     []timedLog
+	anchored int
+	logs     []timedLog // of the later stages
+}
+
+type timedLog struct {
+	log parser.NormalizedLog
+	ts  time.Time
+}
+
+func compileSequence(id string, s *Sequence) ([]compiledStage, error) {
+	if len(s.Stages) < 2 {
+		return nil, fmt.Errorf("rule %s: sequence needs at least two stages", id)
+	}
+	if s.MaxSpan <= 0 {
+		return nil, fmt.Errorf("rule %s: sequence max_span must be positive", id)
+	}
+	if len(s.By) == 0 {
+		return nil, fmt.Errorf("rule %s: sequence needs join fields in by", id)
+	}
+	if s.MaxGroups < 0 {
+		return nil, fmt.Errorf("rule %s: sequence max_groups must not be negative", id)
+	}
+
+	stages := make([]compiledStage, len(s.Stages))
+	for i, st := range s.Stages {
+		c := compiledStage{count: st.Count, by: st.By}
+		if c.count == 0 {
+			c.count = 1
+		}
+		if c.count < 0 {
+			return nil, fmt.Errorf("rule %s: stage %d: count must be positive", id, i)
+		}
+		if len(c.by) == 0 {
+			c.by = s.By
+		}
+		if len(c.by) != len(s.By) {
+			return nil, fmt.Errorf("rule %s: stage %d: by needs %d fields", id, i, len(s.By))
+		}
+		for _, f := range c.by {
+			if !parser.KnownField(f) {
+				return nil, fmt.Errorf("rule %s: stage %d: unknown by field %q", id, i, f)
+			}
+		}
+		if len(st.Match.All)+len(st.Match.Any)+len(st.Match.None) == 0 {
+			return nil, fmt.Errorf("rule %s: stage %d: no match conditions", id, i)
+		}
+		var err error
+		if c.match, err = compileGroup(st.Match.All, st.Match.Any, st.Match.None); err != nil {
+			return nil, fmt.Errorf("rule %s: stage %d: %w", id, i, err)
+		}
+		stages[i] = c
+	}
+	return stages, nil
+}
+
+// advance feeds log into the sequence of its join key and returns all
+// contributing logs once the last stage completes. A log only counts for
+// the stage its key is waiting for, or for the first stage, whose logs
+// are kept to start over from when older ones leave MaxSpan; later
+// stages are tried first so a log matching several stages completes the
+// sequence as early as possible.
+func (r *RuleEngine) advance(rule *compiledRule, log parser.NormalizedLog) ([]parser.NormalizedLog, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seqs := r.sequences[rule.ID]
+	now := time.Now()
+	ts := log.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+	seqs.evict(now)
+
+	for i := len(rule.stages) - 1; i >= 0; i-- {
+		st := rule.stages[i]
+		if !st.match(log) {
+			continue
+		}
+		key, ok := groupKey(st.by, log)
+		if !ok {
+			continue
+		}
+
+		s, ok := seqs.lookup(key)
+		if ok {
+			s.expire(ts.Add(-rule.Sequence.MaxSpan), rule.stages[0].count)
+		}
+		if i == 0 {
+			seqs.get(key, now).first(timedLog{log, ts}, st.count)
+			return nil, false
+		}
+		if !ok || s.stage != i {
+			continue
+		}
+
+		s = seqs.get(key, now)
+		s.logs = append(s.logs, timedLog{log, ts})
+		if s.count++; s.count >= st.count {
+			s.stage++
+			s.count = 0
+		}
+		if s.stage < len(rule.stages) {
+			return nil, false
+		}
+		logs := make([]parser.NormalizedLog, 0, s.anchored+len(s.logs))
+		for _, l := range s.head[:s.anchored] {
+			logs = append(logs, l.log)
+		}
+		for _, l := range s.logs {
+			logs = append(logs, l.log)
+		}
+		seqs.delete(key)
+		return logs, true
+	}
+	return nil, false
+}
+
+// first adds a log of the first stage, which needs count of them. Until
+// they are complete it counts like a threshold. While nothing follows,
+// all first-stage logs within MaxSpan anchor the later stages; after, new
+// ones are kept to start over from.
+func (s *sequenceState) first(l timedLog, count int) {
+	s.head = append(s.head, l)
+	switch {
+	case s.stage == 0:
+		if s.count = len(s.head); s.count >= count {
+			s.stage, s.count, s.anchored = 1, 0, len(s.head)
+		}
+	case s.stage == 1 && s.count == 0:
+		if n := len(s.head) - maxEventsPerGroup; n > 0 {
+			s.head = append(s.head[:0], s.head[n:]...)
+		}
+		s.anchored = len(s.head)
+	case len(s.head)-s.anchored > count:
+		s.head = append(s.head[:s.anchored], s.head[s.anchored+1:]...)
+	}
+}
+
+// expire drops first-stage logs older than cutoff. The later stages keep
+// their progress while enough anchoring logs are left; otherwise it is
+// lost and the sequence starts over from the first-stage logs that are
+// left, which may complete that stage again.
+func (s *sequenceState) expire(cutoff time.Time, count int) {
+	drop := 0
+	for drop < len(s.head) && s.head[drop].ts.Before(cutoff) {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+	s.head = append(s.head[:0], s.head[drop:]...)
+	switch {
+	case s.stage == 0:
+		s.count = len(s.head)
+	case s.anchored-drop >= count:
+		s.anchored -= drop
+	case len(s.head) >= count:
+		s.logs = nil
+		s.stage, s.count, s.anchored = 1, 0, len(s.head)
+	default:
+		s.logs = nil
+		s.stage, s.count, s.anchored = 0, len(s.head), 0
+	}
+}
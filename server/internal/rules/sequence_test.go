@@ -0,0 +1,107 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+const bruteforceSuccess = `
+id: BRUTE_SUCCESS
+severity: critical
+sequence:
+  by: [src_ip]
+  max_span: 10m
+  stages:
+    - match: {all: [{field: event_type, equals: ssh_failed}]}
+      count: 5
+    - match: {all: [{field: event_type, equals: ssh_success}]}
+`
+
+func sshLog(event string, ts time.Time) parser.NormalizedLog {
+	return parser.NormalizedLog{EventType: event, SrcIP: "203.0.113.9", User: "root", Timestamp: ts}
+}
+
+func TestSequenceLongerThanMaxSpan(t *testing.T) {
+	// A failure every 30s for 11 and for 20 minutes.
+	for _, n := range []int{23, 40} {
+		e := newEngine(t, bruteforceSuccess)
+		start := time.Now().Add(-time.Hour)
+
+		var at time.Time
+		for i := 0; i < n; i++ {
+			at = start.Add(time.Duration(i) * 30 * time.Second)
+			if got := e.Check(sshLog("ssh_failed", at)); len(got) != 0 {
+				t.Fatalf("%d failures: failure %d fired %v", n, i, got)
+			}
+		}
+		got := e.Check(sshLog("ssh_success", at.Add(time.Second)))
+		if len(got) != 1 {
+			t.Fatalf("%d failures: success fired %d results, want 1", n, len(got))
+		}
+		// The failures of the last 10 minutes and the success.
+		logs := got[0].Logs
+		if len(logs) != 21 {
+			t.Errorf("%d failures: %d logs, want 21", n, len(logs))
+		}
+		if first := logs[0].Timestamp; at.Add(time.Second).Sub(first) > 10*time.Minute {
+			t.Errorf("%d failures: first log at %v is outside max_span", n, first)
+		}
+	}
+}
+
+func TestSequenceExpires(t *testing.T) {
+	e := newEngine(t, bruteforceSuccess)
+	start := time.Now().Add(-time.Hour)
+
+	for i := 0; i < 5; i++ {
+		e.Check(sshLog("ssh_failed", start.Add(time.Duration(i)*time.Second)))
+	}
+	if got := e.Check(sshLog("ssh_success", start.Add(11*time.Minute))); len(got) != 0 {
+		t.Errorf("success after max_span fired %v", got)
+	}
+	// The failures left the window, so they don't count again.
+	for i := 0; i < 4; i++ {
+		e.Check(sshLog("ssh_failed", start.Add(12*time.Minute)))
+	}
+	if got := e.Check(sshLog("ssh_success", start.Add(13*time.Minute))); len(got) != 0 {
+		t.Errorf("success after 4 failures fired %v", got)
+	}
+}
+
+func TestSSHLoginRootShellSkipsDeniedSudo(t *testing.T) {
+	defs, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	e, err := NewRuleEngine(defs)
+	if err != nil {
+		t.Fatalf("NewRuleEngine: %v", err)
+	}
+	now := time.Now()
+	sudo := func(line string) parser.NormalizedLog {
+		l := parser.ParseLog("auth.log", line)
+		l.Host, l.Timestamp = "web1", now
+		return l
+	}
+
+	e.Check(parser.NormalizedLog{EventType: "ssh_success", Host: "web1", User: "alice", SrcIP: "203.0.113.9", Timestamp: now})
+	for _, msg := range []string{
+		"sudo:    alice : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash",
+		"sudo:    alice : user NOT in sudoers ; TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/sh",
+	} {
+		for _, id := range fired(e, sudo(msg)) {
+			if id == "SSH_LOGIN_ROOT_SHELL" {
+				t.Errorf("fired on %q", msg)
+			}
+		}
+	}
+	ok := false
+	for _, id := range fired(e, sudo("sudo:    alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash")) {
+		ok = ok || id == "SSH_LOGIN_ROOT_SHELL"
+	}
+	if !ok {
+		t.Error("not fired on a root shell")
+	}
+}
@@ -0,0 +1,159 @@
+package rules
+
+import (
+	"container/list"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultMaxGroups bounds the groups tracked per rule when the rule
+	// doesn't set max_groups.
+	defaultMaxGroups = 100_000
+	// maxEventsPerGroup bounds the timestamps kept per group; counts
+	// saturate there, which is far above any sensible threshold.
+	maxEventsPerGroup = 10_000
+)
+
+// groups holds per-group rule state. Groups not updated for ttl of
+// wall-clock time are evicted, as are the least recently updated groups
+// beyond limit, so memory stays bounded when thousands of sources each
+// send a few events.
+type groups[T any] struct {
+	ttl   time.Duration
+	limit int
+	index map[string]*list.Element
+	lru   *list.List // of *group[T], least recently updated first
+}
+
+type group[T any] struct {
+	key     string
+	touched time.Time
+	state   T
+}
+
+func newGroups[T any](ttl time.Duration, limit int) *groups[T] {
+	if limit <= 0 {
+		limit = defaultMaxGroups
+	}
+	return &groups[T]{
+		ttl:   ttl,
+		limit: limit,
+		index: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// get returns the state of key, creating it if needed, and marks it as
+// updated at now.
+func (g *groups[T]) get(key string, now time.Time) *T {
+	g.evict(now)
+
+	e, ok := g.index[key]
+	if !ok {
+		e = g.lru.PushBack(&group[T]{key: key})
+		g.index[key] = e
+		if len(g.index) > g.limit {
+			g.remove(g.lru.Front())
+		}
+	} else {
+		g.lru.MoveToBack(e)
+	}
+	entry := e.Value.(*group[T])
+	entry.touched = now
+	return &entry.state
+}
+
+// lookup returns the state of key without marking it as updated.
+func (g *groups[T]) lookup(key string) (*T, bool) {
+	e, ok := g.index[key]
+	if !ok {
+		return nil, false
+	}
+	return &e.Value.(*group[T]).state, true
+}
+
+// each calls fn with the state of every group, without marking them as
+// updated.
+func (g *groups[T]) each(now time.Time, fn func(key string, state *T)) {
+	g.evict(now)
+	for e := g.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*group[T])
+		fn(entry.key, &entry.state)
+	}
+}
+
+func (g *groups[T]) delete(key string) {
+	if e, ok := g.index[key]; ok {
+		g.remove(e)
+	}
+}
+
+func (g *groups[T]) evict(now time.Time) {
+	for e := g.lru.Front(); e != nil; e = g.lru.Front() {
+		if now.Sub(e.Value.(*group[T]).touched) <= g.ttl {
+			return
+		}
+		g.remove(e)
+	}
+}
+
+func (g *groups[T]) remove(e *list.Element) {
+	delete(g.index, e.Value.(*group[T]).key)
+	g.lru.Remove(e)
+}
+
+func (g *groups[T]) size(now time.Time) int {
+	g.evict(now)
+	return len(g.index)
+}
+
+// slidingWindow counts events per group over the last Window of event
+// time.
+type slidingWindow struct {
+	window time.Duration
+	groups *groups[[]time.Time] // event times per group, sorted
+}
+
+func newSlidingWindow(t *Threshold) *slidingWindow {
+	return &slidingWindow{
+		window: t.Window,
+		groups: newGroups[[]time.Time](t.Window, t.MaxGroups),
+	}
+}
+
+// add records an event at ts for key and returns the number of events of
+// key within the window ending at the newest event seen for key. Events
+// older than that window are ignored and count 0, so they can't fire the
+// threshold again.
+func (w *slidingWindow) add(key string, ts, now time.Time) int {
+	times := w.groups.get(key, now)
+
+	t := *times
+	if n := len(t); n > 0 && ts.Before(t[n-1].Add(-w.window)) {
+		return 0
+	}
+	// Events mostly arrive in order, so search from the end.
+	i := len(t)
+	for i > 0 && t[i-1].After(ts) {
+		i--
+	}
+	t = append(t, time.Time{})
+	copy(t[i+1:], t[i:])
+	t[i] = ts
+
+	start := t[len(t)-1].Add(-w.window)
+	drop := sort.Search(len(t), func(i int) bool { return !t[i].Before(start) })
+	if n := len(t) - maxEventsPerGroup; n > drop {
+		drop = n
+	}
+	if drop > 0 {
+		t = append(t[:0], t[drop:]...)
+	}
+	*times = t
+	return len(t)
+}
+
+func (w *slidingWindow) size(now time.Time) int {
+	return w.groups.size(now)
+}
@@ -0,0 +1,88 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+func TestSlidingWindowOutOfOrder(t *testing.T) {
+	w := newSlidingWindow(&Threshold{Count: 3, Window: time.Minute})
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	now := start
+
+	tests := []struct {
+		at   time.Duration
+		want int
+	}{
+		{50 * time.Second, 1},
+		// Late, but within the minute before the newest event.
+		{10 * time.Second, 2},
+		{30 * time.Second, 3},
+		// Older than the window: ignored.
+		{-20 * time.Second, 0},
+		// The window slides to 30s–90s, dropping the event at 10s.
+		{90 * time.Second, 3},
+	}
+	for _, tt := range tests {
+		if got := w.add("k", start.Add(tt.at), now); got != tt.want {
+			t.Errorf("add(%v) = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestGroupsEviction(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	g := newGroups[int](time.Minute, 2)
+
+	*g.get("a", now) = 1
+	*g.get("b", now) = 2
+	// Updating a makes b the least recently updated group.
+	*g.get("a", now) = 3
+	*g.get("c", now) = 4
+	if _, ok := g.lookup("b"); ok {
+		t.Error("b not evicted beyond max_groups")
+	}
+	if v, ok := g.lookup("a"); !ok || *v != 3 {
+		t.Errorf("a = %v, %v", v, ok)
+	}
+	if n := g.size(now); n != 2 {
+		t.Errorf("size = %d, want 2", n)
+	}
+
+	// Groups not updated for the ttl go.
+	*g.get("c", now.Add(30*time.Second)) = 5
+	if n := g.size(now.Add(61 * time.Second)); n != 1 {
+		t.Errorf("size after ttl = %d, want 1", n)
+	}
+	if _, ok := g.lookup("c"); !ok {
+		t.Error("c evicted before its ttl")
+	}
+}
+
+func TestThresholdMaxGroups(t *testing.T) {
+	e := newEngine(t, `
+id: BRUTE
+severity: high
+match:
+  all: [{field: event_type, equals: ssh_failed}]
+threshold:
+  count: 2
+  window: 1m
+  group_by: [src_ip]
+  max_groups: 1
+`)
+	failure := func(ip string) parser.NormalizedLog {
+		return parser.NormalizedLog{EventType: "ssh_failed", SrcIP: ip, Timestamp: time.Now()}
+	}
+	// 5.6.7.8 evicts the group of 1.2.3.4, which starts over.
+	for _, ip := range []string{"1.2.3.4", "5.6.7.8", "1.2.3.4"} {
+		if got := e.Check(failure(ip)); len(got) != 0 {
+			t.Fatalf("%s fired %v", ip, got)
+		}
+	}
+	if got := e.Check(failure("1.2.3.4")); len(got) != 1 {
+		t.Errorf("fired %d results, want 1", len(got))
+	}
+}
@@ -0,0 +1,214 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/siem/internal/rules"
+)
+
+// condition parses a Sigma detection condition such as
+//
+//	selection and not 1 of filter_*
+//
+// and builds it from the compiled selections. Precedence is not, and, or.
+type condition struct {
+	src        string
+	toks       []token
+	pos        int
+	selections map[string]rules.Condition
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+func parseCondition(src string, selections map[string]rules.Condition) (rules.Condition, error) {
+	if strings.Contains(src, "|") {
+		return rules.Condition{}, unsupported("aggregation in condition %q", src)
+	}
+	p := &condition{src: src, toks: tokenize(src), selections: selections}
+	if len(p.toks) == 0 {
+		return rules.Condition{}, fmt.Errorf("empty condition")
+	}
+	c, err := p.or()
+	if err != nil {
+		return rules.Condition{}, err
+	}
+	if t, ok := p.peek(); ok {
+		return rules.Condition{}, p.errorf(t, "unexpected %q", t.text)
+	}
+	return c, nil
+}
+
+func tokenize(s string) []token {
+	var toks []token
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			toks = append(toks, token{text: s[start:end], pos: start})
+			start = -1
+		}
+	}
+	for i, r := range s {
+		switch {
+		case r == '(' || r == ')':
+			flush(i)
+			toks = append(toks, token{text: string(r), pos: i})
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush(i)
+		case start < 0:
+			start = i
+		}
+	}
+	flush(len(s))
+	return toks
+}
+
+func (p *condition) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *condition) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *condition) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("condition %q at position %d: %s", p.src, t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *condition) or() (rules.Condition, error) {
+	c, err := p.and()
+	if err != nil {
+		return c, err
+	}
+	terms := []rules.Condition{c}
+	for p.keyword("or") {
+		if c, err = p.and(); err != nil {
+			return c, err
+		}
+		terms = append(terms, c)
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return rules.Condition{Any: terms}, nil
+}
+
+func (p *condition) and() (rules.Condition, error) {
+	c, err := p.not()
+	if err != nil {
+		return c, err
+	}
+	terms := []rules.Condition{c}
+	for p.keyword("and") {
+		if c, err = p.not(); err != nil {
+			return c, err
+		}
+		terms = append(terms, c)
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return rules.Condition{All: terms}, nil
+}
+
+func (p *condition) not() (rules.Condition, error) {
+	if p.keyword("not") {
+		c, err := p.not()
+		if err != nil {
+			return c, err
+		}
+		return rules.Condition{None: []rules.Condition{c}}, nil
+	}
+	return p.primary()
+}
+
+func (p *condition) primary() (rules.Condition, error) {
+	t, ok := p.peek()
+	if !ok {
+		return rules.Condition{}, fmt.Errorf("condition %q: unexpected end", p.src)
+	}
+	p.pos++
+
+	switch lower := strings.ToLower(t.text); {
+	case lower == "(":
+		c, err := p.or()
+		if err != nil {
+			return c, err
+		}
+		if !p.keyword(")") {
+			return c, p.errorf(t, "unclosed parenthesis")
+		}
+		return c, nil
+	case lower == "1" || lower == "all":
+		if !p.keyword("of") {
+			return rules.Condition{}, p.errorf(t, "expected \"of\" after %q", t.text)
+		}
+		return p.of(t, lower == "all")
+	case lower == "near":
+		return rules.Condition{}, unsupported("%q in condition %q", t.text, p.src)
+	case lower == "of" || lower == "and" || lower == "or" || lower == ")":
+		return rules.Condition{}, p.errorf(t, "unexpected %q", t.text)
+	case p.keyword("of"):
+		return rules.Condition{}, unsupported("%q of in condition %q, only 1 of and all of", t.text, p.src)
+	}
+
+	sel, ok := p.selections[t.text]
+	if !ok {
+		return rules.Condition{}, p.errorf(t, "unknown selection %q", t.text)
+	}
+	return sel, nil
+}
+
+// of expands "1 of pattern" or "all of pattern"; the pattern is a glob
+// over selection names or "them" for all selections.
+func (p *condition) of(at token, all bool) (rules.Condition, error) {
+	t, ok := p.peek()
+	if !ok {
+		return rules.Condition{}, p.errorf(at, "expected selection pattern after \"of\"")
+	}
+	p.pos++
+
+	pattern := t.text
+	them := strings.EqualFold(pattern, "them")
+	if them {
+		pattern = "*"
+	}
+	var names []string
+	for name := range p.selections {
+		// Sigma excludes selections starting with "_" from "them".
+		if them && strings.HasPrefix(name, "_") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return rules.Condition{}, p.errorf(t, "no selection matches %q", t.text)
+	}
+	sort.Strings(names)
+
+	terms := make([]rules.Condition, len(names))
+	for i, name := range names {
+		terms[i] = p.selections[name]
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	if all {
+		return rules.Condition{All: terms}, nil
+	}
+	return rules.Condition{Any: terms}, nil
+}
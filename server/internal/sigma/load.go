@@ -0,0 +1,47 @@
+package sigma
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/siem/internal/rules"
+)
+
+// Load converts every .yml/.yaml file below dir. Files that can't be
+// converted don't stop the others; each is reported in skipped with its
+// path and reason. err is only set when dir can't be read.
+func Load(dir string, m *Mapping) (loaded []rules.Rule, skipped []error, err error) {
+	seen := make(map[string]string)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if d.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		r, err := Convert(data, m)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: %w", path, err))
+			return nil
+		}
+		if prev, ok := seen[r.ID]; ok {
+			skipped = append(skipped, fmt.Errorf("%s: duplicate rule id %s (first in %s)", path, r.ID, prev))
+			return nil
+		}
+		seen[r.ID] = path
+		loaded = append(loaded, r)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("sigma dir %s: %w", dir, err)
+	}
+	return loaded, skipped, nil
+}
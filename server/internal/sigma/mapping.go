@@ -0,0 +1,104 @@
+package sigma
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/rules"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed mapping.yaml
+var defaultMapping []byte
+
+// Mapping translates Sigma field names and log sources to NormalizedLog.
+//
+//	fields:
+//	  SourceIp: src_ip
+//	logsources:
+//	  - service: sshd
+//	    conditions:
+//	      - field: source
+//	        contains: auth
+type Mapping struct {
+	Fields     map[string]string `yaml:"fields"`
+	LogSources []LogSource       `yaml:"logsources"`
+}
+
+// LogSource adds Conditions to every rule whose logsource has the given
+// product, service and category. Empty attributes match anything. Rules
+// whose logsource matches no entry are evaluated against all logs.
+type LogSource struct {
+	Product    string            `yaml:"product"`
+	Service    string            `yaml:"service"`
+	Category   string            `yaml:"category"`
+	Conditions []rules.Condition `yaml:"conditions"`
+}
+
+// DefaultMapping returns the built-in mapping.
+func DefaultMapping() (*Mapping, error) {
+	var m Mapping
+	if err := yaml.Unmarshal(defaultMapping, &m); err != nil {
+		return nil, fmt.Errorf("default mapping: %w", err)
+	}
+	return &m, nil
+}
+
+// LoadMapping returns the built-in mapping extended by the file at path.
+// Fields in the file override built-in ones and its log sources are
+// tried before the built-in ones. An empty path returns the defaults.
+func LoadMapping(path string) (*Mapping, error) {
+	m, err := DefaultMapping()
+	if err != nil || path == "" {
+		return m, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var custom Mapping
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&custom); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for k, v := range custom.Fields {
+		if !parser.KnownField(v) {
+			return nil, fmt.Errorf("%s: field %s mapped to unknown field %q", path, k, v)
+		}
+		m.Fields[k] = v
+	}
+	m.LogSources = append(custom.LogSources, m.LogSources...)
+	return m, nil
+}
+
+// field returns the NormalizedLog field for a Sigma field name. Names
+// that already are NormalizedLog fields map to themselves.
+func (m *Mapping) field(name string) (string, bool) {
+	if f, ok := m.Fields[name]; ok {
+		return f, true
+	}
+	if parser.KnownField(name) {
+		return name, true
+	}
+	return "", false
+}
+
+// logSource returns the conditions selecting the logs of a Sigma log
+// source. A log source without mapping is unsupported rather than matched
+// against every log.
+func (m *Mapping) logSource(ls logSource) ([]rules.Condition, error) {
+	for _, s := range m.LogSources {
+		if (s.Product == "" || s.Product == ls.Product) &&
+			(s.Service == "" || s.Service == ls.Service) &&
+			(s.Category == "" || s.Category == ls.Category) {
+			return s.Conditions, nil
+		}
+	}
+	return nil, unsupported("logsource %s/%s/%s has no mapping", ls.Product, ls.Service, ls.Category)
+}
@@ -0,0 +1,400 @@
+// Package sigma converts Sigma rules (https://sigmahq.io) into rules for
+// the rule engine.
+//
+// Supported: logsource (through the Mapping), keyword and field
+// selections, value lists, wildcards, null, the modifiers contains,
+// startswith, endswith, all, re (with i), cidr, gt, gte, lt and lte, and
+// conditions with and, or, not, parentheses, "1 of" and "all of".
+// Anything else, such as aggregations, near, timeframe or encoding
+// modifiers, fails the rule with an *UnsupportedError.
+package sigma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/siem/internal/rules"
+	"gopkg.in/yaml.v3"
+)
+
+// UnsupportedError reports a Sigma construct the converter can't express.
+type UnsupportedError struct {
+	Construct string
+}
+
+func (e *UnsupportedError) Error() string {
+	return "unsupported sigma construct: " + e.Construct
+}
+
+func unsupported(format string, args ...any) error {
+	return &UnsupportedError{Construct: fmt.Sprintf(format, args...)}
+}
+
+type sigmaRule struct {
+	Title       string         `yaml:"title"`
+	ID          string         `yaml:"id"`
+	Status      string         `yaml:"status"`
+	Description string         `yaml:"description"`
+	Level       string         `yaml:"level"`
+	Tags        []string       `yaml:"tags"`
+	LogSource   logSource      `yaml:"logsource"`
+	Detection   map[string]any `yaml:"detection"`
+}
+
+type logSource struct {
+	Product  string `yaml:"product"`
+	Service  string `yaml:"service"`
+	Category string `yaml:"category"`
+}
+
+// levels maps Sigma levels to severity and base score.
+var levels = map[string]struct {
+	severity string
+	score    float64
+}{
+	"informational": {"LOW", 0.1},
+	"low":           {"LOW", 0.3},
+	"medium":        {"MEDIUM", 0.5},
+	"high":          {"HIGH", 0.8},
+	"critical":      {"CRITICAL", 1.0},
+}
+
+// Convert turns one Sigma rule file into a rule. Rule collections
+// (several YAML documents) are not supported.
+func Convert(data []byte, m *Mapping) (rules.Rule, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var s sigmaRule
+	if err := dec.Decode(&s); err != nil {
+		return rules.Rule{}, err
+	}
+	var extra any
+	if err := dec.Decode(&extra); !errors.Is(err, io.EOF) {
+		return rules.Rule{}, unsupported("rule collection with several documents")
+	}
+
+	r, err := convert(s, m)
+	if err != nil {
+		return rules.Rule{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return rules.Rule{}, err
+	}
+	return r, nil
+}
+
+func convert(s sigmaRule, m *Mapping) (rules.Rule, error) {
+	if s.Title == "" {
+		return rules.Rule{}, fmt.Errorf("missing title")
+	}
+	level, ok := levels[strings.ToLower(s.Level)]
+	if !ok {
+		return rules.Rule{}, fmt.Errorf("invalid level %q", s.Level)
+	}
+	if len(s.Detection) == 0 {
+		return rules.Rule{}, fmt.Errorf("missing detection")
+	}
+	if _, ok := s.Detection["timeframe"]; ok {
+		return rules.Rule{}, unsupported("timeframe")
+	}
+
+	selections := make(map[string]rules.Condition, len(s.Detection))
+	for name, v := range s.Detection {
+		if name == "condition" {
+			continue
+		}
+		c, err := selection(v, m)
+		if err != nil {
+			return rules.Rule{}, fmt.Errorf("selection %s: %w", name, err)
+		}
+		selections[name] = c
+	}
+
+	var cond rules.Condition
+	switch v := s.Detection["condition"].(type) {
+	case string:
+		c, err := parseCondition(v, selections)
+		if err != nil {
+			return rules.Rule{}, err
+		}
+		cond = c
+	case []any:
+		// A list of conditions means any of them.
+		for _, item := range v {
+			src, ok := item.(string)
+			if !ok {
+				return rules.Rule{}, fmt.Errorf("condition must be a string")
+			}
+			c, err := parseCondition(src, selections)
+			if err != nil {
+				return rules.Rule{}, err
+			}
+			cond.Any = append(cond.Any, c)
+		}
+	default:
+		return rules.Rule{}, fmt.Errorf("missing condition")
+	}
+
+	source, err := m.logSource(s.LogSource)
+	if err != nil {
+		return rules.Rule{}, err
+	}
+
+	id := strings.TrimSpace(s.ID)
+	if id == "" {
+		id = "SIGMA_" + strings.Trim(nonWord.ReplaceAllString(strings.ToUpper(s.Title), "_"), "_")
+	}
+	return rules.Rule{
+		ID:          id,
+		Title:       s.Title,
+		Description: s.Description,
+		Severity:    level.severity,
+		Score:       level.score,
+		Message:     strings.ReplaceAll(s.Title, "{{", `{{"{{"}}`) + " on {{.host}}",
+		Mitre:       mitre(s.Tags),
+		Match: rules.Match{
+			All: append(source, cond),
+		},
+	}, nil
+}
+
+var nonWord = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// mitre extracts technique IDs from tags like attack.t1059.004.
+func mitre(tags []string) []string {
+	var out []string
+	for _, t := range tags {
+		t = strings.ToLower(t)
+		if id, ok := strings.CutPrefix(t, "attack.t"); ok && id != "" && id[0] >= '0' && id[0] <= '9' {
+			out = append(out, "T"+id)
+		}
+	}
+	return out
+}
+
+// selection converts a detection entry: a map of field conditions that
+// must all hold, a list of such maps of which one must hold, or a list
+// of keywords searched in the message.
+func selection(v any, m *Mapping) (rules.Condition, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		return fieldMap(v, m)
+	case []any:
+		var alts []rules.Condition
+		for _, item := range v {
+			var c rules.Condition
+			var err error
+			if fm, ok := item.(map[string]any); ok {
+				c, err = fieldMap(fm, m)
+			} else {
+				c, err = keyword(item)
+			}
+			if err != nil {
+				return c, err
+			}
+			alts = append(alts, c)
+		}
+		if len(alts) == 0 {
+			return rules.Condition{}, fmt.Errorf("empty list")
+		}
+		return rules.Condition{Any: alts}, nil
+	default:
+		return keyword(v)
+	}
+}
+
+func keyword(v any) (rules.Condition, error) {
+	s, ok := scalar(v)
+	if !ok {
+		return rules.Condition{}, fmt.Errorf("invalid keyword %v", v)
+	}
+	return valueCondition("msg", s, "contains")
+}
+
+func fieldMap(fm map[string]any, m *Mapping) (rules.Condition, error) {
+	if len(fm) == 0 {
+		return rules.Condition{}, fmt.Errorf("empty selection")
+	}
+	keys := make([]string, 0, len(fm))
+	for k := range fm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var all []rules.Condition
+	for _, key := range keys {
+		c, err := fieldCondition(key, fm[key], m)
+		if err != nil {
+			return c, err
+		}
+		all = append(all, c)
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return rules.Condition{All: all}, nil
+}
+
+// fieldCondition converts "Field|modifier...: value(s)".
+func fieldCondition(key string, v any, m *Mapping) (rules.Condition, error) {
+	parts := strings.Split(key, "|")
+	name := parts[0]
+	if name == "" {
+		return rules.Condition{}, unsupported("keyword modifiers %q", key)
+	}
+	field, ok := m.field(name)
+	if !ok {
+		return rules.Condition{}, unsupported("field %q has no mapping", name)
+	}
+
+	op, matchAll, reFlags := "", false, ""
+	for _, mod := range parts[1:] {
+		switch mod {
+		case "contains", "startswith", "endswith", "re", "cidr", "gt", "gte", "lt", "lte":
+			if op != "" {
+				return rules.Condition{}, unsupported("modifiers %q combined with %q in %q", op, mod, key)
+			}
+			op = mod
+		case "all":
+			matchAll = true
+		case "i", "m", "s":
+			reFlags += mod
+		default:
+			return rules.Condition{}, unsupported("modifier %q in %q", mod, key)
+		}
+	}
+	if reFlags != "" && op != "re" {
+		return rules.Condition{}, fmt.Errorf("regex flags without re in %q", key)
+	}
+
+	values, ok := v.([]any)
+	if !ok {
+		values = []any{v}
+	}
+	var conds []rules.Condition
+	for _, val := range values {
+		var c rules.Condition
+		var err error
+		if val == nil {
+			if op != "" {
+				return rules.Condition{}, fmt.Errorf("null value with modifier in %q", key)
+			}
+			// null means the field is missing or empty.
+			c = rules.Condition{None: []rules.Condition{{Field: field, Regex: "."}}}
+		} else if s, ok := scalar(val); !ok {
+			return rules.Condition{}, fmt.Errorf("invalid value for %q", key)
+		} else if op == "re" {
+			if reFlags != "" {
+				s = "(?" + reFlags + ")" + s
+			}
+			c = rules.Condition{Field: field, Regex: s}
+		} else {
+			c, err = valueCondition(field, s, op)
+		}
+		if err != nil {
+			return rules.Condition{}, fmt.Errorf("%q: %w", key, err)
+		}
+		conds = append(conds, c)
+	}
+
+	switch {
+	case len(conds) == 0:
+		return rules.Condition{}, fmt.Errorf("no values for %q", key)
+	case len(conds) == 1:
+		return conds[0], nil
+	case matchAll:
+		return rules.Condition{All: conds}, nil
+	default:
+		return rules.Condition{Any: conds}, nil
+	}
+}
+
+// valueCondition matches field against a Sigma value. String matching is
+// case-insensitive and honours the wildcards * and ?.
+func valueCondition(field, value, op string) (rules.Condition, error) {
+	switch op {
+	case "cidr":
+		return rules.Condition{Field: field, CIDR: []string{value}}, nil
+	case "gt", "gte", "lt", "lte":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return rules.Condition{}, fmt.Errorf("%s needs a number", op)
+		}
+		c := rules.Condition{Field: field}
+		switch op {
+		case "gt":
+			c.Gt = &f
+		case "gte":
+			c.Gte = &f
+		case "lt":
+			c.Lt = &f
+		default:
+			c.Lte = &f
+		}
+		return c, nil
+	}
+
+	expr, plain, wild := wildcard(value)
+	if !wild {
+		switch op {
+		case "":
+			return rules.Condition{Field: field, Equals: &plain, IgnoreCase: true}, nil
+		case "contains":
+			if plain != "" {
+				return rules.Condition{Field: field, Contains: plain, IgnoreCase: true}, nil
+			}
+		}
+	}
+	switch op {
+	case "":
+		expr = "^" + expr + "$"
+	case "startswith":
+		expr = "^" + expr
+	case "endswith":
+		expr = expr + "$"
+	}
+	if expr == "" {
+		expr = ".*"
+	}
+	return rules.Condition{Field: field, Regex: expr, IgnoreCase: true}, nil
+}
+
+// wildcard translates a Sigma value to a regular expression and to its
+// unescaped text, and reports whether it has unescaped wildcards.
+func wildcard(v string) (expr, plain string, wild bool) {
+	var re, txt strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '\\' && i+1 < len(v) && (v[i+1] == '*' || v[i+1] == '?' || v[i+1] == '\\'):
+			i++
+			re.WriteString(regexp.QuoteMeta(v[i : i+1]))
+			txt.WriteByte(v[i])
+		case c == '*':
+			re.WriteString(".*")
+			wild = true
+		case c == '?':
+			re.WriteString(".")
+			wild = true
+		default:
+			re.WriteString(regexp.QuoteMeta(v[i : i+1]))
+			txt.WriteByte(c)
+		}
+	}
+	return re.String(), txt.String(), wild
+}
+
+func scalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// ErrTooManyBuckets is returned for histograms with more than
+// MaxBuckets buckets.
+var ErrTooManyBuckets = errors.New("storage: too many histogram buckets")
+
+const MaxBuckets = 10000
+
+// Bucket is one interval of a histogram.
+type Bucket struct {
+	Time  time.Time `json:"ts"`
+	Count int64     `json:"count"`
+}
+
+// TermCount is one value of a terms or cardinality aggregation.
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int64  `json:"count"`
+}
+
+// bucketOf returns the index of the interval holding t, counting from the
+// Unix epoch.
+func bucketOf(t time.Time, interval time.Duration) int64 {
+	n := t.UnixNano()
+	k := n / int64(interval)
+	if n%int64(interval) < 0 {
+		k--
+	}
+	return k
+}
+
+// histogram turns the counts by bucket index into buckets, including the
+// empty ones from q.From (or the first bucket) to q.To (or the last).
+func histogram(counts map[int64]int64, q LogQuery, interval time.Duration) ([]Bucket, error) {
+	first, last, ok := int64(0), int64(0), false
+	for k := range counts {
+		if !ok || k < first {
+			first = k
+		}
+		if !ok || k > last {
+			last = k
+		}
+		ok = true
+	}
+	if !q.From.IsZero() {
+		first, ok = bucketOf(q.From, interval), true
+	}
+	if !q.To.IsZero() {
+		last = bucketOf(q.To.Add(-1), interval)
+		if !ok {
+			first, ok = last, true
+		}
+	}
+
+	buckets := []Bucket{}
+	if !ok || last < first {
+		return buckets, nil
+	}
+	if last-first >= MaxBuckets {
+		return nil, ErrTooManyBuckets
+	}
+	for k := first; k <= last; k++ {
+		buckets = append(buckets, Bucket{
+			Time:  time.Unix(0, k*int64(interval)).UTC(),
+			Count: counts[k],
+		})
+	}
+	return buckets, nil
+}
+
+var errInterval = errors.New("storage: histogram interval must be positive")
+
+func checkFields(fields ...string) error {
+	for _, f := range fields {
+		if f != "" && !parser.KnownField(f) {
+			return fmt.Errorf("storage: unknown log field %q", f)
+		}
+	}
+	return nil
+}
+
+func termsSize(size int) int {
+	switch {
+	case size <= 0:
+		return 10
+	case size > 1000:
+		return 1000
+	}
+	return size
+}
+
+// top returns the size largest counts, ties ordered by term.
+func top(counts map[string]int64, size int) []TermCount {
+	size = termsSize(size)
+	out := make([]TermCount, 0, len(counts))
+	for term, n := range counts {
+		out = append(out, TermCount{Term: term, Count: n})
+	}
+	slices.SortFunc(out, func(a, b TermCount) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return cmp.Compare(a.Term, b.Term)
+	})
+	if len(out) > size {
+		out = out[:size]
+	}
+	return out
+}
@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+const (
+	defaultMaxLogs   = 10000
+	defaultMaxAlerts = 1000
+	// maxTrackedBatches bounds the out-of-order sequence numbers kept per
+	// agent. Redeliveries only come from the agent's unacked window, which
+	// is far smaller.
+	maxTrackedBatches = 4096
+)
+
+// Memory keeps the newest logs and alerts in process memory. When a limit
+// is exceeded the oldest tenth is dropped. Nothing survives a restart.
+type Memory struct {
+	mu            sync.RWMutex
+	logs          []storedLog
+	lastLogID     uint64
+	alerts        []Alert
+	maxLogs       int
+	maxAlerts     int
+	batches       map[string]*seqSet
+	lastAlertID   uint
+	dedup         map[string]uint // dedup key → newest alert ID
+	silences      []Silence
+	lastSilenceID uint
+	metrics       map[MetricName]*memSeries
+}
+
+type storedLog struct {
+	id  uint64
+	log parser.NormalizedLog
+}
+
+// seqSet tracks stored batch sequence numbers of one agent: everything
+// below floor plus the members of seen.
+type seqSet struct {
+	floor uint64
+	seen  map[uint64]struct{}
+}
+
+func (s *seqSet) has(seq uint64) bool {
+	_, ok := s.seen[seq]
+	return seq < s.floor || ok
+}
+
+func (s *seqSet) add(seq uint64) {
+	s.seen[seq] = struct{}{}
+	for {
+		if _, ok := s.seen[s.floor]; !ok {
+			break
+		}
+		delete(s.seen, s.floor)
+		s.floor++
+	}
+	if len(s.seen) > maxTrackedBatches {
+		// A gap that never fills (batches evicted from the agent spool)
+		// would pin floor; give up on the lower half instead.
+		keys := slices.Sorted(maps.Keys(s.seen))
+		for _, k := range keys[:len(keys)/2] {
+			delete(s.seen, k)
+		}
+		s.floor = keys[len(keys)/2-1] + 1
+	}
+}
+
+func NewMemory(maxLogs, maxAlerts int) *Memory {
+	if maxLogs <= 0 {
+		maxLogs = defaultMaxLogs
+	}
+	if maxAlerts <= 0 {
+		maxAlerts = defaultMaxAlerts
+	}
+	return &Memory{
+		maxLogs:   maxLogs,
+		maxAlerts: maxAlerts,
+		batches:   make(map[string]*seqSet),
+		dedup:     make(map[string]uint),
+		metrics:   make(map[MetricName]*memSeries),
+	}
+}
+
+func (m *Memory) SaveLogs(_ context.Context, logs []parser.NormalizedLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addLogs(logs)
+	return nil
+}
+
+func (m *Memory) addLogs(logs []parser.NormalizedLog) {
+	for _, l := range logs {
+		m.lastLogID++
+		m.logs = append(m.logs, storedLog{id: m.lastLogID, log: l})
+	}
+	if len(m.logs) > m.maxLogs {
+		m.logs = trim(m.logs, m.maxLogs)
+	}
+	m.addMetrics(logs)
+}
+
+func (m *Memory) SaveAlerts(_ context.Context, alerts []Alert) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addAlerts(alerts)
+	return nil
+}
+
+// addAlerts keeps alerts sorted by ID, so they can be found by binary
+// search.
+func (m *Memory) addAlerts(alerts []Alert) {
+	for i := range alerts {
+		a := &alerts[i]
+		if open := m.find(m.dedup[a.DedupKey]); open != nil && open.dedups(a) {
+			open.merge(a)
+			*a = *open
+			a.History = nil
+			continue
+		}
+
+		m.lastAlertID++
+		a.ID = m.lastAlertID
+		a.created()
+		m.alerts = append(m.alerts, *a)
+		if a.DedupKey != "" {
+			m.dedup[a.DedupKey] = a.ID
+		}
+	}
+
+	if len(m.alerts) > m.maxAlerts {
+		m.alerts = trim(m.alerts, m.maxAlerts)
+		for k, id := range m.dedup {
+			if id < m.alerts[0].ID {
+				delete(m.dedup, k)
+			}
+		}
+	}
+}
+
+func (m *Memory) BatchSeen(_ context.Context, agentID string, seq uint64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	set, ok := m.batches[agentID]
+	return ok && set.has(seq), nil
+}
+
+func (m *Memory) SaveBatch(_ context.Context, b Batch) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set, ok := m.batches[b.AgentID]
+	if !ok {
+		set = &seqSet{floor: 1, seen: make(map[uint64]struct{})}
+		m.batches[b.AgentID] = set
+	}
+	if set.has(b.Seq) {
+		return true, nil
+	}
+	set.add(b.Seq)
+
+	m.addLogs(b.Logs)
+	m.addAlerts(b.Alerts)
+	return false, nil
+}
+
+func (m *Memory) RecentLogs(_ context.Context, limit int) ([]parser.NormalizedLog, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored := tail(m.logs, limit)
+	logs := make([]parser.NormalizedLog, len(stored))
+	for i, s := range stored {
+		logs[i] = s.log
+	}
+	return logs, nil
+}
+
+// SearchLogs scans all logs; the memory store is small enough for that.
+func (m *Memory) SearchLogs(_ context.Context, q LogQuery) (LogPage, error) {
+	var after *cursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return LogPage{}, err
+		}
+		after = &c
+	}
+
+	match := q.matcher()
+	m.mu.RLock()
+	var found []storedLog
+	for _, s := range m.logs {
+		if match(&s.log) && (after == nil || after.after(s.log.Timestamp, s.id, q.Asc)) {
+			found = append(found, s)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(found, func(a, b storedLog) int {
+		c := a.log.Timestamp.Compare(b.log.Timestamp)
+		if c == 0 {
+			c = cmp.Compare(a.id, b.id)
+		}
+		if !q.Asc {
+			c = -c
+		}
+		return c
+	})
+
+	page := LogPage{Logs: []parser.NormalizedLog{}}
+	limit := q.limit()
+	for i, s := range found {
+		if i == limit {
+			last := found[i-1]
+			page.Next = cursor{TS: last.log.Timestamp, ID: last.id}.encode()
+			break
+		}
+		page.Logs = append(page.Logs, s.log)
+	}
+	return page, nil
+}
+
+func (m *Memory) LogHistogram(_ context.Context, q LogQuery, interval time.Duration) ([]Bucket, error) {
+	if interval <= 0 {
+		return nil, errInterval
+	}
+	counts := make(map[int64]int64)
+	m.eachLog(q, func(l *parser.NormalizedLog) {
+		counts[bucketOf(l.Timestamp, interval)]++
+	})
+	return histogram(counts, q, interval)
+}
+
+func (m *Memory) TopTerms(_ context.Context, q LogQuery, field string, size int) ([]TermCount, error) {
+	if err := checkFields(field); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	m.eachLog(q, func(l *parser.NormalizedLog) {
+		if v, _ := l.Field(field); v != "" {
+			counts[v]++
+		}
+	})
+	return top(counts, size), nil
+}
+
+func (m *Memory) Cardinality(_ context.Context, q LogQuery, field, by string, size int) ([]TermCount, error) {
+	if err := checkFields(field, by); err != nil {
+		return nil, err
+	}
+	distinct := make(map[string]map[string]struct{})
+	m.eachLog(q, func(l *parser.NormalizedLog) {
+		v, _ := l.Field(field)
+		if v == "" {
+			return
+		}
+		group := ""
+		if by != "" {
+			group, _ = l.Field(by)
+		}
+		if distinct[group] == nil {
+			distinct[group] = make(map[string]struct{})
+		}
+		distinct[group][v] = struct{}{}
+	})
+	if by == "" {
+		return []TermCount{{Count: int64(len(distinct[""]))}}, nil
+	}
+	counts := make(map[string]int64, len(distinct))
+	for group, values := range distinct {
+		counts[group] = int64(len(values))
+	}
+	return top(counts, size), nil
+}
+
+// eachLog calls fn for the logs matching the filters of q.
+func (m *Memory) eachLog(q LogQuery, fn func(l *parser.NormalizedLog)) {
+	match := q.matcher()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.logs {
+		if l := &m.logs[i].log; match(l) {
+			fn(l)
+		}
+	}
+}
+
+func (m *Memory) RecentAlerts(_ context.Context, limit int) ([]Alert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return tail(m.alerts, limit), nil
+}
+
+func (m *Memory) ListAlerts(_ context.Context, f AlertFilter) ([]Alert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var out []Alert
+	for i := len(m.alerts) - 1; i >= 0 && len(out) < f.limit(); i-- {
+		if a := m.alerts[i]; f.match(&a) {
+			a.History = nil
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) Alert(_ context.Context, id uint) (Alert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a := m.find(id)
+	if a == nil {
+		return Alert{}, ErrNotFound
+	}
+	out := *a
+	out.History = slices.Clone(a.History)
+	return out, nil
+}
+
+func (m *Memory) UpdateAlert(_ context.Context, id uint, u AlertUpdate) (Alert, error) {
+	if err := u.Validate(); err != nil {
+		return Alert{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.find(id)
+	if a == nil {
+		return Alert{}, ErrNotFound
+	}
+	a.History = append(a.History, a.apply(u, time.Now())...)
+	out := *a
+	out.History = slices.Clone(a.History)
+	return out, nil
+}
+
+func (m *Memory) CreateSilence(_ context.Context, s Silence) (Silence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastSilenceID++
+	s.ID = m.lastSilenceID
+	s.CreatedAt = time.Now()
+	m.silences = append(m.silences, s)
+	return s, nil
+}
+
+func (m *Memory) ListSilences(_ context.Context, includeExpired bool) ([]Silence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Expired silences are kept for a day so they can still be listed.
+	now := time.Now()
+	m.silences = slices.DeleteFunc(m.silences, func(s Silence) bool {
+		return now.Sub(s.ExpiresAt) > 24*time.Hour
+	})
+
+	var out []Silence
+	for i := len(m.silences) - 1; i >= 0; i-- {
+		if s := m.silences[i]; includeExpired || s.ExpiresAt.After(now) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) ExpireSilence(_ context.Context, id uint) (Silence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.silences {
+		if s := &m.silences[i]; s.ID == id {
+			if now := time.Now(); s.ExpiresAt.After(now) {
+				s.ExpiresAt = now
+			}
+			return *s, nil
+		}
+	}
+	return Silence{}, ErrNotFound
+}
+
+func (m *Memory) find(id uint) *Alert {
+	i := sort.Search(len(m.alerts), func(i int) bool { return m.alerts[i].ID >= id })
+	if i < len(m.alerts) && m.alerts[i].ID == id {
+		return &m.alerts[i]
+	}
+	return nil
+}
+
+// memSeries holds the raw samples and rollups of one metric of one host,
+// each ordered by time.
+type memSeries struct {
+	raw     []rawSample
+	rollups []memRollup
+}
+
+type rawSample struct {
+	time  time.Time
+	value float64
+}
+
+type memRollup struct {
+	time          time.Time // start of the minute
+	count         int64
+	sum, min, max float64
+}
+
+func (m *Memory) addMetrics(logs []parser.NormalizedLog) {
+	for _, s := range metricSamples(logs) {
+		key := MetricName{Host: s.host, Metric: s.metric}
+		series := m.metrics[key]
+		if series == nil {
+			series = &memSeries{}
+			m.metrics[key] = series
+		}
+		// Samples mostly arrive in order; spooled ones may come late.
+		i, _ := slices.BinarySearchFunc(series.raw, s.time, func(r rawSample, t time.Time) int {
+			return cmp.Or(r.time.Compare(t), -1)
+		})
+		series.raw = slices.Insert(series.raw, i, rawSample{s.time, s.value})
+
+		minute := minuteOf(s.time)
+		i, found := slices.BinarySearchFunc(series.rollups, minute, func(r memRollup, t time.Time) int {
+			return r.time.Compare(t)
+		})
+		if !found {
+			series.rollups = slices.Insert(series.rollups, i, memRollup{time: minute, min: s.value, max: s.value})
+		}
+		r := &series.rollups[i]
+		r.count++
+		r.sum += s.value
+		r.min = min(r.min, s.value)
+		r.max = max(r.max, s.value)
+	}
+}
+
+func (m *Memory) MetricRange(_ context.Context, q MetricQuery) (MetricSeries, error) {
+	out, err := metricPlan(q, time.Now())
+	if err != nil {
+		return out, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	series := m.metrics[MetricName{Host: q.Host, Metric: q.Metric}]
+	if series == nil {
+		return out, nil
+	}
+	if out.Resolution == ResolutionRaw {
+		for _, r := range series.raw {
+			if !r.time.Before(out.From) && r.time.Before(out.To) {
+				out.add(r.time, 1, r.value, r.value, r.value)
+			}
+		}
+	} else {
+		for _, r := range series.rollups {
+			if !r.time.Before(minuteOf(out.From)) && r.time.Before(out.To) {
+				out.add(r.time, r.count, r.sum, r.min, r.max)
+			}
+		}
+	}
+	out.finish()
+	return out, nil
+}
+
+func (m *Memory) MetricNames(_ context.Context, host string) ([]MetricName, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := []MetricName{}
+	for name := range m.metrics {
+		if host == "" || name.Host == host {
+			out = append(out, name)
+		}
+	}
+	slices.SortFunc(out, func(a, b MetricName) int {
+		return cmp.Or(cmp.Compare(a.Host, b.Host), cmp.Compare(a.Metric, b.Metric))
+	})
+	return out, nil
+}
+
+func (m *Memory) PruneMetrics(_ context.Context, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rawCutoff, rollupCutoff := now.Add(-RawMetricRetention), now.Add(-RollupRetention)
+	for key, series := range m.metrics {
+		series.raw = slices.DeleteFunc(series.raw, func(r rawSample) bool { return r.time.Before(rawCutoff) })
+		series.rollups = slices.DeleteFunc(series.rollups, func(r memRollup) bool { return r.time.Before(rollupCutoff) })
+		if len(series.rollups) == 0 {
+			delete(m.metrics, key)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Stats(context.Context) (Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Stats{Logs: int64(len(m.logs)), Alerts: int64(len(m.alerts))}, nil
+}
+
+func (m *Memory) Close() error { return nil }
+
+// trim drops the oldest entries so that s fits into max with a tenth of
+// headroom, avoiding a copy on every append once the limit is reached.
+func trim[T any](s []T, max int) []T {
+	keep := max - max/10
+	out := make([]T, keep)
+	copy(out, s[len(s)-keep:])
+	return out
+}
+
+func tail[T any](s []T, limit int) []T {
+	if limit > 0 && len(s) > limit {
+		s = s[len(s)-limit:]
+	}
+	out := make([]T, len(s))
+	copy(out, s)
+	return out
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+func TestMemoryAlertDedup(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(0, 0)
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	hit := func(key string, at time.Duration, score float64) Alert {
+		return Alert{
+			Rule:        "SSH_BRUTEFORCE",
+			Score:       score,
+			Message:     at.String(),
+			Log:         parser.NormalizedLog{SrcIP: key},
+			Timestamp:   start.Add(at),
+			DedupKey:    "SSH_BRUTEFORCE|src_ip=" + key,
+			DedupWindow: time.Hour,
+		}
+	}
+
+	alerts := []Alert{
+		hit("1.2.3.4", 0, 0.8),
+		hit("1.2.3.4", time.Minute, 0.9),
+		hit("5.6.7.8", time.Minute, 0.8),
+		// Within the window of the last hit, not of the first.
+		hit("1.2.3.4", 61*time.Minute, 0.85),
+	}
+	if err := m.SaveAlerts(ctx, alerts); err != nil {
+		t.Fatal(err)
+	}
+	if alerts[1].ID != alerts[0].ID || alerts[3].ID != alerts[0].ID || alerts[2].ID == alerts[0].ID {
+		t.Errorf("ids = %d %d %d %d", alerts[0].ID, alerts[1].ID, alerts[2].ID, alerts[3].ID)
+	}
+	a, err := m.Alert(ctx, alerts[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Count != 3 || !a.LastSeen.Equal(start.Add(61*time.Minute)) || a.Score != 0.9 || a.Message != "1h1m0s" {
+		t.Errorf("merged alert: count %d, last seen %v, score %g, message %q", a.Count, a.LastSeen, a.Score, a.Message)
+	}
+
+	// Past the window, or once the alert is resolved, a hit opens a new
+	// alert.
+	late := []Alert{hit("5.6.7.8", 2*time.Hour, 0.8)}
+	if err := m.SaveAlerts(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+	if late[0].ID == alerts[2].ID {
+		t.Error("hit past the window merged")
+	}
+	if _, err := m.UpdateAlert(ctx, a.ID, AlertUpdate{Status: StatusResolved}); err != nil {
+		t.Fatal(err)
+	}
+	resolved := []Alert{hit("1.2.3.4", 62*time.Minute, 0.8)}
+	if err := m.SaveAlerts(ctx, resolved); err != nil {
+		t.Fatal(err)
+	}
+	if resolved[0].ID == a.ID {
+		t.Error("hit merged into a resolved alert")
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// Metric samples are kept raw for RawMetricRetention and as one-minute
+// rollups for RollupRetention.
+const (
+	RawMetricRetention = 24 * time.Hour
+	RollupRetention    = 30 * 24 * time.Hour
+	RollupInterval     = time.Minute
+	// MaxMetricPoints is the number of points MetricRange aims at when
+	// the query has no step.
+	MaxMetricPoints = 500
+)
+
+// Metric resolutions of a MetricSeries.
+const (
+	ResolutionRaw    = "raw"
+	ResolutionRollup = "1m"
+)
+
+// MetricQuery selects one metric of one host for MetricRange. To defaults
+// to now and From to an hour before To. Step is the interval points are
+// averaged over; 0 picks one for about MaxMetricPoints points.
+type MetricQuery struct {
+	Host     string
+	Metric   string
+	From, To time.Time
+	Step     time.Duration
+}
+
+// MetricPoint is the average, minimum and maximum of the Count samples of
+// one step, starting at Time.
+type MetricPoint struct {
+	Time  time.Time `json:"ts"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Count int64     `json:"count"`
+}
+
+type MetricSeries struct {
+	Host       string        `json:"host"`
+	Metric     string        `json:"metric"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Step       time.Duration `json:"-"`
+	Resolution string        `json:"resolution"`
+	Points     []MetricPoint `json:"points"`
+}
+
+// MetricName is a metric a host reported.
+type MetricName struct {
+	Host   string `json:"host"`
+	Metric string `json:"metric"`
+}
+
+type metricSample struct {
+	host, metric string
+	time         time.Time
+	value        float64
+}
+
+// metricSamples returns the metrics of the metrics events among logs,
+// ordered by host, metric and time.
+func metricSamples(logs []parser.NormalizedLog) []metricSample {
+	var samples []metricSample
+	for _, l := range logs {
+		if l.EventType != "metrics" {
+			continue
+		}
+		for name, v := range l.Metrics {
+			samples = append(samples, metricSample{host: l.Host, metric: name, time: l.Timestamp, value: v})
+		}
+	}
+	slices.SortFunc(samples, func(a, b metricSample) int {
+		return cmp.Or(cmp.Compare(a.host, b.host), cmp.Compare(a.metric, b.metric), a.time.Compare(b.time))
+	})
+	return samples
+}
+
+// metricRollup is the aggregate of the samples of one series in one
+// minute.
+type metricRollup struct {
+	host, metric  string
+	time          time.Time // start of the minute
+	count         int64
+	sum, min, max float64
+}
+
+// metricRollups aggregates samples, as sorted by metricSamples, per series
+// and minute, in the same order.
+func metricRollups(samples []metricSample) []metricRollup {
+	var rollups []metricRollup
+	for _, s := range samples {
+		minute := minuteOf(s.time)
+		if n := len(rollups); n > 0 {
+			if r := &rollups[n-1]; r.host == s.host && r.metric == s.metric && r.time.Equal(minute) {
+				r.count++
+				r.sum += s.value
+				r.min = min(r.min, s.value)
+				r.max = max(r.max, s.value)
+				continue
+			}
+		}
+		rollups = append(rollups, metricRollup{
+			host: s.host, metric: s.metric, time: minute,
+			count: 1, sum: s.value, min: s.value, max: s.value,
+		})
+	}
+	return rollups
+}
+
+// metricPlan fills in the defaults of q and picks the resolution: raw
+// samples when they still cover q.From and the step is below a minute,
+// else rollups with a step of whole minutes.
+func metricPlan(q MetricQuery, now time.Time) (MetricSeries, error) {
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-time.Hour)
+	}
+	step := q.Step
+	if step <= 0 {
+		step = max((q.To.Sub(q.From) / MaxMetricPoints).Round(time.Second), time.Second)
+	}
+	s := MetricSeries{Host: q.Host, Metric: q.Metric, From: q.From.UTC(), To: q.To.UTC(), Resolution: ResolutionRaw}
+	if step >= RollupInterval || q.From.Before(now.Add(-RawMetricRetention)) {
+		s.Resolution = ResolutionRollup
+		step = (step + RollupInterval - 1) / RollupInterval * RollupInterval
+	}
+	if q.To.Sub(q.From)/step >= MaxBuckets {
+		return s, ErrTooManyBuckets
+	}
+	s.Step = step
+	s.Points = []MetricPoint{}
+	return s, nil
+}
+
+// add merges a raw sample or rollup into the point of its step. Points
+// must be added in time order.
+func (s *MetricSeries) add(t time.Time, count int64, sum, lo, hi float64) {
+	start := time.Unix(0, bucketOf(t, s.Step)*int64(s.Step)).UTC()
+	n := len(s.Points)
+	if n == 0 || !s.Points[n-1].Time.Equal(start) {
+		s.Points = append(s.Points, MetricPoint{Time: start, Min: lo, Max: hi})
+		n++
+	}
+	p := &s.Points[n-1]
+	// Avg holds the sum until finish.
+	p.Avg += sum
+	p.Count += count
+	p.Min = min(p.Min, lo)
+	p.Max = max(p.Max, hi)
+}
+
+func (s *MetricSeries) finish() {
+	for i := range s.Points {
+		if p := &s.Points[i]; p.Count > 0 {
+			p.Avg /= float64(p.Count)
+		}
+	}
+}
+
+// minuteOf is the start of the rollup holding t.
+func minuteOf(t time.Time) time.Time {
+	return t.UTC().Truncate(RollupInterval)
+}
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS logs (
+    id         BIGSERIAL PRIMARY KEY,
+    ts         TIMESTAMPTZ NOT NULL,
+    host       TEXT NOT NULL DEFAULT '',
+    source     TEXT NOT NULL DEFAULT '',
+    level      TEXT NOT NULL DEFAULT '',
+    event_type TEXT NOT NULL DEFAULT '',
+    src_ip     INET,
+    username   TEXT NOT NULL DEFAULT '',
+    -- full NormalizedLog document: raw line and any extra fields
+    doc        JSONB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id       BIGSERIAL PRIMARY KEY,
+    alert_ts TIMESTAMPTZ NOT NULL,
+    rule     TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    score    DOUBLE PRECISION NOT NULL DEFAULT 0,
+    doc      JSONB NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE INDEX IF NOT EXISTS logs_ts_idx ON logs (ts);
+CREATE INDEX IF NOT EXISTS logs_host_ts_idx ON logs (host, ts);
+CREATE INDEX IF NOT EXISTS logs_event_type_ts_idx ON logs (event_type, ts);
+CREATE INDEX IF NOT EXISTS logs_src_ip_idx ON logs USING GIST (src_ip inet_ops);
+CREATE INDEX IF NOT EXISTS logs_doc_idx ON logs USING GIN (doc jsonb_path_ops);
+
+CREATE INDEX IF NOT EXISTS alerts_ts_idx ON alerts (alert_ts);
+CREATE INDEX IF NOT EXISTS alerts_rule_ts_idx ON alerts (rule, alert_ts);
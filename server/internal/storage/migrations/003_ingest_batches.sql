@@ -0,0 +1,8 @@
+-- (agent_id, seq) of every stored agent batch, used to drop redelivered
+-- batches.
+CREATE TABLE IF NOT EXISTS ingest_batches (
+    agent_id    TEXT NOT NULL,
+    seq         BIGINT NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (agent_id, seq)
+);
@@ -0,0 +1,24 @@
+ALTER TABLE alerts
+    ADD COLUMN IF NOT EXISTS status     TEXT NOT NULL DEFAULT 'new',
+    ADD COLUMN IF NOT EXISTS assignee   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS host       TEXT NOT NULL DEFAULT '';
+
+UPDATE alerts SET updated_at = alert_ts, host = COALESCE(doc->'log'->>'host', '')
+    WHERE updated_at IS NULL;
+ALTER TABLE alerts ALTER COLUMN updated_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS alerts_status_id_idx ON alerts (status, id);
+
+CREATE TABLE IF NOT EXISTS alert_events (
+    id         BIGSERIAL PRIMARY KEY,
+    alert_id   BIGINT NOT NULL REFERENCES alerts (id) ON DELETE CASCADE,
+    ts         TIMESTAMPTZ NOT NULL,
+    actor      TEXT NOT NULL DEFAULT '',
+    action     TEXT NOT NULL,
+    from_value TEXT NOT NULL DEFAULT '',
+    to_value   TEXT NOT NULL DEFAULT '',
+    comment    TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS alert_events_alert_id_idx ON alert_events (alert_id, id);
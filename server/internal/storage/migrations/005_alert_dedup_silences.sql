@@ -0,0 +1,20 @@
+ALTER TABLE alerts
+    ADD COLUMN IF NOT EXISTS dedup_key TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS count     INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS last_seen TIMESTAMPTZ;
+
+UPDATE alerts SET last_seen = alert_ts WHERE last_seen IS NULL;
+ALTER TABLE alerts ALTER COLUMN last_seen SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS alerts_dedup_key_idx ON alerts (dedup_key, id) WHERE dedup_key <> '';
+
+CREATE TABLE IF NOT EXISTS silences (
+    id         BIGSERIAL PRIMARY KEY,
+    matchers   JSONB NOT NULL,
+    comment    TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS silences_expires_at_idx ON silences (expires_at);
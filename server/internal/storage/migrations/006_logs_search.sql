@@ -0,0 +1,3 @@
+-- keyset pagination of /api/v1/logs orders by (ts, id)
+CREATE INDEX IF NOT EXISTS logs_ts_id_idx ON logs (ts, id);
+CREATE INDEX IF NOT EXISTS logs_username_ts_idx ON logs (username, ts);
@@ -0,0 +1,24 @@
+-- Metric samples of metrics events: raw for a day, then as one-minute
+-- rollups for 30 days. Old rows are removed by PruneMetrics.
+CREATE TABLE IF NOT EXISTS metric_samples (
+    host   TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    ts     TIMESTAMPTZ NOT NULL,
+    value  DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx ON metric_samples (host, metric, ts);
+CREATE INDEX IF NOT EXISTS metric_samples_ts_idx ON metric_samples (ts);
+
+CREATE TABLE IF NOT EXISTS metric_rollups (
+    host   TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    ts     TIMESTAMPTZ NOT NULL, -- start of the minute
+    count  BIGINT NOT NULL,
+    sum    DOUBLE PRECISION NOT NULL,
+    min    DOUBLE PRECISION NOT NULL,
+    max    DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (host, metric, ts)
+);
+
+CREATE INDEX IF NOT EXISTS metric_rollups_ts_idx ON metric_rollups (ts);
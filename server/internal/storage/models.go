@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/query"
+)
+
+// ErrNotFound is returned for lookups of unknown IDs.
+var ErrNotFound = errors.New("storage: not found")
+
+// Alert statuses. New, acknowledged and in-progress alerts are active.
+const (
+	StatusNew           = "new"
+	StatusAcknowledged  = "acknowledged"
+	StatusInProgress    = "in-progress"
+	StatusResolved      = "resolved"
+	StatusFalsePositive = "false-positive"
+)
+
+var (
+	Statuses       = []string{StatusNew, StatusAcknowledged, StatusInProgress, StatusResolved, StatusFalsePositive}
+	ActiveStatuses = []string{StatusNew, StatusAcknowledged, StatusInProgress}
+)
+
+type Alert struct {
+	ID        uint                   `json:"id"`
+	Rule      string                 `json:"rule"`
+	Severity  string                 `json:"severity"`
+	Score     float64                `json:"score"`
+	Message   string                 `json:"message"`
+	Mitre     []string               `json:"mitre,omitempty"`
+	Log       parser.NormalizedLog   `json:"log"`
+	Logs      []parser.NormalizedLog `json:"logs,omitempty"` // all logs behind a sequence alert
+	Timestamp time.Time              `json:"alert_ts"`
+
+	// Hits with the same DedupKey within DedupWindow of LastSeen update
+	// the open alert instead of creating a new one.
+	DedupKey    string        `json:"dedup_key,omitempty"`
+	DedupWindow time.Duration `json:"-"`
+	Count       int           `json:"count"`
+	LastSeen    time.Time     `json:"last_seen"`
+
+	Status    string       `json:"status"`
+	Assignee  string       `json:"assignee,omitempty"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	History   []AlertEvent `json:"history,omitempty"`
+}
+
+// AlertEvent is one entry of an alert's history.
+type AlertEvent struct {
+	Time    time.Time `json:"ts"`
+	Actor   string    `json:"actor,omitempty"`
+	Action  string    `json:"action"` // status, assign or comment
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// AlertFilter selects alerts for ListAlerts. Empty fields match all
+// alerts; Limit defaults to 100.
+type AlertFilter struct {
+	Status   []string
+	Severity []string
+	Rule     string
+	Host     string
+	Assignee string
+	Query    *query.Query // parsed with AlertSchema
+	From, To time.Time
+	Limit    int
+}
+
+// AlertUpdate changes an alert. Empty Status and nil Assignee leave them
+// unchanged; a non-empty Comment is added to the history.
+type AlertUpdate struct {
+	Actor    string
+	Status   string
+	Assignee *string
+	Comment  string
+}
+
+// Silence mutes new alerts matching all Matchers until ExpiresAt.
+type Silence struct {
+	ID        uint      `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Matcher tests an alert's rule, severity or a field of its log.
+type Matcher struct {
+	Field string `json:"field"`
+	Op    string `json:"op,omitempty"` // "=" (default), "!=" or "=~" (regex)
+	Value string `json:"value"`
+}
+
+// Batch is everything derived from one agent batch.
+type Batch struct {
+	AgentID string
+	Seq     uint64
+	Logs    []parser.NormalizedLog
+	Alerts  []Alert
+}
+
+func ValidStatus(s string) bool {
+	return slices.Contains(Statuses, s)
+}
+
+// Validate checks the update before any backend applies it.
+func (u AlertUpdate) Validate() error {
+	if u.Status != "" && !ValidStatus(u.Status) {
+		return fmt.Errorf("invalid status %q", u.Status)
+	}
+	if u.Status == "" && u.Assignee == nil && u.Comment == "" {
+		return fmt.Errorf("nothing to update")
+	}
+	return nil
+}
+
+// apply changes a and returns the history entries for the changes.
+func (a *Alert) apply(u AlertUpdate, now time.Time) []AlertEvent {
+	var events []AlertEvent
+	if u.Status != "" && u.Status != a.Status {
+		events = append(events, AlertEvent{Time: now, Actor: u.Actor, Action: "status", From: a.Status, To: u.Status})
+		a.Status = u.Status
+	}
+	if u.Assignee != nil && *u.Assignee != a.Assignee {
+		events = append(events, AlertEvent{Time: now, Actor: u.Actor, Action: "assign", From: a.Assignee, To: *u.Assignee})
+		a.Assignee = *u.Assignee
+	}
+	if u.Comment != "" {
+		events = append(events, AlertEvent{Time: now, Actor: u.Actor, Action: "comment", Comment: u.Comment})
+	}
+	if len(events) > 0 {
+		a.UpdatedAt = now
+	}
+	return events
+}
+
+// created prepares a new alert for storing.
+func (a *Alert) created() {
+	if a.Status == "" {
+		a.Status = StatusNew
+	}
+	if a.UpdatedAt.IsZero() {
+		a.UpdatedAt = a.Timestamp
+	}
+	if a.Count == 0 {
+		a.Count = 1
+	}
+	if a.LastSeen.IsZero() {
+		a.LastSeen = a.Timestamp
+	}
+}
+
+// dedups reports whether hit should be folded into the open alert a.
+func (a *Alert) dedups(hit *Alert) bool {
+	return hit.DedupKey != "" && hit.DedupKey == a.DedupKey &&
+		slices.Contains(ActiveStatuses, a.Status) &&
+		!hit.Timestamp.After(a.LastSeen.Add(hit.DedupWindow))
+}
+
+// merge folds hit into a. The latest message wins and the score only
+// grows, as threshold scores rise with the attack.
+func (a *Alert) merge(hit *Alert) {
+	a.Count += max(hit.Count, 1)
+	if hit.Timestamp.After(a.LastSeen) {
+		a.LastSeen = hit.Timestamp
+	}
+	a.Score = max(a.Score, hit.Score)
+	a.Message = hit.Message
+}
+
+func (f AlertFilter) match(a *Alert) bool {
+	return (len(f.Status) == 0 || slices.Contains(f.Status, a.Status)) &&
+		(len(f.Severity) == 0 || slices.Contains(f.Severity, a.Severity)) &&
+		(f.Rule == "" || a.Rule == f.Rule) &&
+		(f.Host == "" || a.Log.Host == f.Host) &&
+		(f.Assignee == "" || a.Assignee == f.Assignee) &&
+		(f.From.IsZero() || !a.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || a.Timestamp.Before(f.To)) &&
+		(f.Query == nil || f.Query.Match(a))
+}
+
+func (f AlertFilter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return 100
+	case f.Limit > 1000:
+		return 1000
+	}
+	return f.Limit
+}
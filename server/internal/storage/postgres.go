@@ -0,0 +1,933 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/siem/internal/parser"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock is the pg_advisory_xact_lock key that serializes schema
+// migrations when several server replicas start at once.
+const migrationLock = 0x5133_4d
+
+// Postgres stores logs and alerts in PostgreSQL. Frequently filtered
+// fields get their own indexed columns; the complete document is kept in
+// a JSONB column so fields added later need no schema change.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: open: %w", err)
+	}
+	db.SetMaxOpenConns(20)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("postgres: ping: %w", err)
+	}
+
+	p := &Postgres{db: db}
+	if err := p.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Postgres) migrate(ctx context.Context) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("postgres: list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("postgres: migrate: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return fmt.Errorf("postgres: migration lock: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("postgres: create schema_migrations: %w", err)
+	}
+
+	for _, name := range names {
+		var applied bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, name,
+		).Scan(&applied); err != nil {
+			return fmt.Errorf("postgres: check migration %s: %w", name, err)
+		}
+		if applied {
+			continue
+		}
+
+		body, err := migrations.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("postgres: read migration %s: %w", name, err)
+		}
+		if _, err := tx.ExecContext(ctx, string(body)); err != nil {
+			return fmt.Errorf("postgres: apply migration %s: %w", name, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version) VALUES ($1)`, name,
+		); err != nil {
+			return fmt.Errorf("postgres: record migration %s: %w", name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("postgres: commit migrations: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) SaveLogs(ctx context.Context, logs []parser.NormalizedLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	return p.inTx(ctx, "save logs", func(tx *sql.Tx) error {
+		return insertLogs(ctx, tx, logs)
+	})
+}
+
+func (p *Postgres) SaveAlerts(ctx context.Context, alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	return p.inTx(ctx, "save alerts", func(tx *sql.Tx) error {
+		return insertAlerts(ctx, tx, alerts)
+	})
+}
+
+func (p *Postgres) BatchSeen(ctx context.Context, agentID string, seq uint64) (bool, error) {
+	var seen bool
+	err := p.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM ingest_batches WHERE agent_id = $1 AND seq = $2)`,
+		agentID, int64(seq),
+	).Scan(&seen)
+	if err != nil {
+		return false, fmt.Errorf("postgres: batch seen: %w", err)
+	}
+	return seen, nil
+}
+
+func (p *Postgres) SaveBatch(ctx context.Context, b Batch) (bool, error) {
+	duplicate := false
+	err := p.inTx(ctx, "save batch", func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO ingest_batches (agent_id, seq) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			b.AgentID, int64(b.Seq),
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// Another replica stored it first.
+			duplicate = true
+			return nil
+		}
+
+		if err := insertLogs(ctx, tx, b.Logs); err != nil {
+			return err
+		}
+		return insertAlerts(ctx, tx, b.Alerts)
+	})
+	return duplicate, err
+}
+
+func (p *Postgres) inTx(ctx context.Context, op string, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("postgres: %s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return fmt.Errorf("postgres: %s: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("postgres: %s: %w", op, err)
+	}
+	return nil
+}
+
+func insertLogs(ctx context.Context, tx *sql.Tx, logs []parser.NormalizedLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO logs
+		(ts, host, source, level, event_type, src_ip, username, doc)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, l := range logs {
+		doc, err := json.Marshal(l)
+		if err != nil {
+			return fmt.Errorf("encode log: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx,
+			l.Timestamp, l.Host, l.Source, l.Level, l.EventType, inet(l.SrcIP), l.User, doc,
+		); err != nil {
+			return fmt.Errorf("insert log: %w", err)
+		}
+	}
+	return insertMetrics(ctx, tx, logs)
+}
+
+// insertMetrics stores the samples of metrics events and adds them to
+// their rollups, each with one statement. Rollups come sorted, so
+// concurrent batches lock rollup rows in the same order.
+func insertMetrics(ctx context.Context, tx *sql.Tx, logs []parser.NormalizedLog) error {
+	samples := metricSamples(logs)
+	if len(samples) == 0 {
+		return nil
+	}
+	var (
+		hosts   = make([]string, len(samples))
+		metrics = make([]string, len(samples))
+		times   = make([]time.Time, len(samples))
+		values  = make([]float64, len(samples))
+	)
+	for i, s := range samples {
+		hosts[i], metrics[i], times[i], values[i] = s.host, s.metric, s.time, s.value
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO metric_samples (host, metric, ts, value)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::float8[])`,
+		hosts, metrics, times, values,
+	); err != nil {
+		return fmt.Errorf("insert metrics: %w", err)
+	}
+
+	rollups := metricRollups(samples)
+	var (
+		rollupHosts   = make([]string, len(rollups))
+		rollupMetrics = make([]string, len(rollups))
+		minutes       = make([]time.Time, len(rollups))
+		counts        = make([]int64, len(rollups))
+		sums          = make([]float64, len(rollups))
+		mins          = make([]float64, len(rollups))
+		maxs          = make([]float64, len(rollups))
+	)
+	for i, r := range rollups {
+		rollupHosts[i], rollupMetrics[i], minutes[i] = r.host, r.metric, r.time
+		counts[i], sums[i], mins[i], maxs[i] = r.count, r.sum, r.min, r.max
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO metric_rollups AS r (host, metric, ts, count, sum, min, max)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::bigint[], $5::float8[], $6::float8[], $7::float8[])
+		ON CONFLICT (host, metric, ts) DO UPDATE SET
+			count = r.count + EXCLUDED.count,
+			sum = r.sum + EXCLUDED.sum,
+			min = LEAST(r.min, EXCLUDED.min),
+			max = GREATEST(r.max, EXCLUDED.max)`,
+		rollupHosts, rollupMetrics, minutes, counts, sums, mins, maxs,
+	); err != nil {
+		return fmt.Errorf("update metric rollups: %w", err)
+	}
+	return nil
+}
+
+func insertAlerts(ctx context.Context, tx *sql.Tx, alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO alerts
+		(alert_ts, rule, severity, score, host, status, assignee, updated_at,
+		 dedup_key, count, last_seen, doc)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if err := lockDedupKeys(ctx, tx, alerts); err != nil {
+		return fmt.Errorf("dedup alert: %w", err)
+	}
+	for i := range alerts {
+		a := &alerts[i]
+		if a.DedupKey != "" {
+			merged, err := dedupAlert(ctx, tx, a)
+			if err != nil {
+				return fmt.Errorf("dedup alert: %w", err)
+			}
+			if merged {
+				continue
+			}
+		}
+
+		a.created()
+		doc, err := json.Marshal(a)
+		if err != nil {
+			return fmt.Errorf("encode alert: %w", err)
+		}
+		var id int64
+		if err := stmt.QueryRowContext(ctx,
+			a.Timestamp, a.Rule, a.Severity, a.Score, a.Log.Host, a.Status, a.Assignee, a.UpdatedAt,
+			a.DedupKey, a.Count, a.LastSeen, doc,
+		).Scan(&id); err != nil {
+			return fmt.Errorf("insert alert: %w", err)
+		}
+		a.ID = uint(id)
+	}
+	return nil
+}
+
+// lockDedupKeys serializes hits of the dedup keys of alerts across
+// batches and replicas until the tx ends, so concurrent first hits don't
+// open two alerts. The locks are taken in ascending order of their ids, so
+// transactions sharing keys can't deadlock.
+func lockDedupKeys(ctx context.Context, tx *sql.Tx, alerts []Alert) error {
+	var ids []int64
+	for _, a := range alerts {
+		if a.DedupKey != "" {
+			h := fnv.New64a()
+			h.Write([]byte(a.DedupKey))
+			ids = append(ids, int64(h.Sum64()))
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range slices.Compact(ids) {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupAlert folds a into the newest open alert with its dedup key and
+// replaces a with the result. It reports false when there is none. The
+// key must be locked by lockDedupKeys.
+func dedupAlert(ctx context.Context, tx *sql.Tx, a *Alert) (bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+alertColumns+` FROM alerts
+		WHERE dedup_key = $1 ORDER BY id DESC LIMIT 1 FOR UPDATE`, a.DedupKey)
+	if err != nil {
+		return false, err
+	}
+	found, err := scanAlerts(rows)
+	if err != nil {
+		return false, err
+	}
+	if len(found) == 0 || !found[0].dedups(a) {
+		return false, nil
+	}
+
+	open := found[0]
+	open.merge(a)
+	if _, err := tx.ExecContext(ctx, `UPDATE alerts
+		SET count = $2, last_seen = $3, score = $4, doc = jsonb_set(doc, '{message}', to_jsonb($5::TEXT))
+		WHERE id = $1`,
+		int64(open.ID), open.Count, open.LastSeen, open.Score, open.Message,
+	); err != nil {
+		return false, err
+	}
+	*a = open
+	return true, nil
+}
+
+func (p *Postgres) RecentLogs(ctx context.Context, limit int) ([]parser.NormalizedLog, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT doc FROM logs ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: recent logs: %w", err)
+	}
+	logs, err := scanDocs[parser.NormalizedLog](rows)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: recent logs: %w", err)
+	}
+	slices.Reverse(logs)
+	return logs, nil
+}
+
+func (p *Postgres) SearchLogs(ctx context.Context, q LogQuery) (LogPage, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := logWhere(q, arg)
+
+	order, cmp := "DESC", "<"
+	if q.Asc {
+		order, cmp = "ASC", ">"
+	}
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return LogPage{}, err
+		}
+		where = append(where, "(ts, id) "+cmp+" ("+arg(c.TS)+", "+arg(int64(c.ID))+")")
+	}
+
+	query := `SELECT id, ts, doc FROM logs` + whereClause(where)
+	limit := q.limit()
+	query += ` ORDER BY ts ` + order + `, id ` + order + ` LIMIT ` + arg(limit+1)
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return LogPage{}, fmt.Errorf("postgres: search logs: %w", err)
+	}
+	defer rows.Close()
+
+	page := LogPage{Logs: []parser.NormalizedLog{}}
+	var last cursor
+	for rows.Next() {
+		var (
+			id  int64
+			ts  time.Time
+			doc []byte
+			l   parser.NormalizedLog
+		)
+		if err := rows.Scan(&id, &ts, &doc); err != nil {
+			return LogPage{}, fmt.Errorf("postgres: search logs: %w", err)
+		}
+		if len(page.Logs) == limit {
+			page.Next = last.encode()
+			break
+		}
+		if err := json.Unmarshal(doc, &l); err != nil {
+			return LogPage{}, fmt.Errorf("postgres: decode log %d: %w", id, err)
+		}
+		page.Logs = append(page.Logs, l)
+		last = cursor{TS: ts, ID: uint64(id)}
+	}
+	if err := rows.Err(); err != nil {
+		return LogPage{}, fmt.Errorf("postgres: search logs: %w", err)
+	}
+	return page, nil
+}
+
+func (p *Postgres) LogHistogram(ctx context.Context, q LogQuery, interval time.Duration) ([]Bucket, error) {
+	if interval <= 0 {
+		return nil, errInterval
+	}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	bucket := "floor(extract(epoch FROM ts)::FLOAT8 / " + arg(interval.Seconds()) + "::FLOAT8)::BIGINT"
+	rows, err := p.db.QueryContext(ctx, `SELECT `+bucket+` AS bucket, count(*) FROM logs`+
+		whereClause(logWhere(q, arg))+` GROUP BY bucket`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: log histogram: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int64)
+	for rows.Next() {
+		var k, n int64
+		if err := rows.Scan(&k, &n); err != nil {
+			return nil, fmt.Errorf("postgres: log histogram: %w", err)
+		}
+		counts[k] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: log histogram: %w", err)
+	}
+	return histogram(counts, q, interval)
+}
+
+func (p *Postgres) TopTerms(ctx context.Context, q LogQuery, field string, size int) ([]TermCount, error) {
+	if err := checkFields(field); err != nil {
+		return nil, err
+	}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	col := logColumn("doc", arg)(field)
+	where := append(logWhere(q, arg), col+" <> ''")
+	terms, err := p.termCounts(ctx, `SELECT `+col+`, count(*) FROM logs`+whereClause(where)+
+		` GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT `+arg(termsSize(size)), args)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: top terms: %w", err)
+	}
+	return terms, nil
+}
+
+func (p *Postgres) Cardinality(ctx context.Context, q LogQuery, field, by string, size int) ([]TermCount, error) {
+	if err := checkFields(field, by); err != nil {
+		return nil, err
+	}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	column := logColumn("doc", arg)
+	col := column(field)
+	where := append(logWhere(q, arg), col+" <> ''")
+	query := `SELECT '', count(DISTINCT ` + col + `) FROM logs` + whereClause(where)
+	if by != "" {
+		query = `SELECT COALESCE(` + column(by) + `, ''), count(DISTINCT ` + col + `) FROM logs` + whereClause(where) +
+			` GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT ` + arg(termsSize(size))
+	}
+	terms, err := p.termCounts(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: cardinality: %w", err)
+	}
+	return terms, nil
+}
+
+func (p *Postgres) termCounts(ctx context.Context, query string, args []any) ([]TermCount, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []TermCount{}
+	for rows.Next() {
+		var t TermCount
+		if err := rows.Scan(&t.Term, &t.Count); err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, rows.Err()
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(where, " AND ")
+}
+
+// logWhere returns the conditions of the filters of q, without its
+// cursor.
+func logWhere(q LogQuery, arg func(any) string) []string {
+	var where []string
+	for _, f := range [][2]string{
+		{"host", q.Host}, {"source", q.Source}, {"level", q.Level},
+		{"event_type", q.EventType}, {"username", q.User},
+	} {
+		if f[1] != "" {
+			where = append(where, f[0]+" = "+arg(f[1]))
+		}
+	}
+	switch {
+	case q.SrcIP == "":
+	case q.srcNet() != nil:
+		where = append(where, "src_ip <<= "+arg(q.SrcIP)+"::INET")
+	case net.ParseIP(q.SrcIP) != nil:
+		where = append(where, "src_ip = "+arg(q.SrcIP)+"::INET")
+	default:
+		// Values that aren't addresses are only kept in doc.
+		where = append(where, "doc->>'src_ip' = "+arg(q.SrcIP))
+	}
+	if q.Text != "" {
+		where = append(where, "doc->>'msg' ILIKE "+arg("%"+likeEscaper.Replace(q.Text)+"%"))
+	}
+	if q.Query != nil {
+		where = append(where, querySQL(q.Query.Root, logColumn("doc", arg), arg, map[string]string{"src_ip": "src_ip"}))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "ts >= "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "ts < "+arg(q.To))
+	}
+	return where
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// alertColumns are read by scanAlerts. The columns changed after an
+// alert is created override the values stored in doc.
+const alertColumns = `id, doc, status, assignee, updated_at, count, last_seen, score`
+
+func (p *Postgres) RecentAlerts(ctx context.Context, limit int) ([]Alert, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+alertColumns+` FROM alerts ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: recent alerts: %w", err)
+	}
+	alerts, err := scanAlerts(rows)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: recent alerts: %w", err)
+	}
+	slices.Reverse(alerts)
+	return alerts, nil
+}
+
+func (p *Postgres) ListAlerts(ctx context.Context, f AlertFilter) ([]Alert, error) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if len(f.Status) > 0 {
+		where = append(where, "status = ANY("+arg(f.Status)+")")
+	}
+	if len(f.Severity) > 0 {
+		where = append(where, "severity = ANY("+arg(f.Severity)+")")
+	}
+	if f.Rule != "" {
+		where = append(where, "rule = "+arg(f.Rule))
+	}
+	if f.Host != "" {
+		where = append(where, "host = "+arg(f.Host))
+	}
+	if f.Assignee != "" {
+		where = append(where, "assignee = "+arg(f.Assignee))
+	}
+	if f.Query != nil {
+		where = append(where, querySQL(f.Query.Root, alertColumn(arg), arg, nil))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "alert_ts >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "alert_ts < "+arg(f.To))
+	}
+
+	query := `SELECT ` + alertColumns + ` FROM alerts`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY id DESC LIMIT ` + arg(f.limit())
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list alerts: %w", err)
+	}
+	alerts, err := scanAlerts(rows)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+func (p *Postgres) Alert(ctx context.Context, id uint) (Alert, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+alertColumns+` FROM alerts WHERE id = $1`, int64(id))
+	if err != nil {
+		return Alert{}, fmt.Errorf("postgres: get alert: %w", err)
+	}
+	alerts, err := scanAlerts(rows)
+	if err != nil {
+		return Alert{}, fmt.Errorf("postgres: get alert: %w", err)
+	}
+	if len(alerts) == 0 {
+		return Alert{}, ErrNotFound
+	}
+	a := alerts[0]
+
+	rows, err = p.db.QueryContext(ctx, `SELECT ts, actor, action, from_value, to_value, comment
+		FROM alert_events WHERE alert_id = $1 ORDER BY id`, int64(id))
+	if err != nil {
+		return Alert{}, fmt.Errorf("postgres: alert history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AlertEvent
+		if err := rows.Scan(&e.Time, &e.Actor, &e.Action, &e.From, &e.To, &e.Comment); err != nil {
+			return Alert{}, fmt.Errorf("postgres: alert history: %w", err)
+		}
+		a.History = append(a.History, e)
+	}
+	if err := rows.Err(); err != nil {
+		return Alert{}, fmt.Errorf("postgres: alert history: %w", err)
+	}
+	return a, nil
+}
+
+func (p *Postgres) UpdateAlert(ctx context.Context, id uint, u AlertUpdate) (Alert, error) {
+	if err := u.Validate(); err != nil {
+		return Alert{}, err
+	}
+	err := p.inTx(ctx, "update alert", func(tx *sql.Tx) error {
+		var a Alert
+		err := tx.QueryRowContext(ctx,
+			`SELECT status, assignee FROM alerts WHERE id = $1 FOR UPDATE`, int64(id),
+		).Scan(&a.Status, &a.Assignee)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		events := a.apply(u, time.Now())
+		if len(events) == 0 {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE alerts SET status = $2, assignee = $3, updated_at = $4 WHERE id = $1`,
+			int64(id), a.Status, a.Assignee, a.UpdatedAt,
+		); err != nil {
+			return err
+		}
+		for _, e := range events {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO alert_events
+				(alert_id, ts, actor, action, from_value, to_value, comment)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				int64(id), e.Time, e.Actor, e.Action, e.From, e.To, e.Comment,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Alert{}, err
+	}
+	return p.Alert(ctx, id)
+}
+
+func (p *Postgres) CreateSilence(ctx context.Context, s Silence) (Silence, error) {
+	matchers, err := json.Marshal(s.Matchers)
+	if err != nil {
+		return Silence{}, fmt.Errorf("postgres: encode silence: %w", err)
+	}
+	var id int64
+	err = p.db.QueryRowContext(ctx, `INSERT INTO silences
+		(matchers, comment, created_by, expires_at) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		matchers, s.Comment, s.CreatedBy, s.ExpiresAt,
+	).Scan(&id, &s.CreatedAt)
+	if err != nil {
+		return Silence{}, fmt.Errorf("postgres: create silence: %w", err)
+	}
+	s.ID = uint(id)
+	return s, nil
+}
+
+const silenceColumns = `id, matchers, comment, created_by, created_at, expires_at`
+
+func (p *Postgres) ListSilences(ctx context.Context, includeExpired bool) ([]Silence, error) {
+	query := `SELECT ` + silenceColumns + ` FROM silences`
+	if !includeExpired {
+		query += ` WHERE expires_at > now()`
+	}
+	rows, err := p.db.QueryContext(ctx, query+` ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list silences: %w", err)
+	}
+	silences, err := scanSilences(rows)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list silences: %w", err)
+	}
+	return silences, nil
+}
+
+func (p *Postgres) ExpireSilence(ctx context.Context, id uint) (Silence, error) {
+	rows, err := p.db.QueryContext(ctx, `UPDATE silences SET expires_at = LEAST(expires_at, now())
+		WHERE id = $1 RETURNING `+silenceColumns, int64(id))
+	if err != nil {
+		return Silence{}, fmt.Errorf("postgres: expire silence: %w", err)
+	}
+	silences, err := scanSilences(rows)
+	if err != nil {
+		return Silence{}, fmt.Errorf("postgres: expire silence: %w", err)
+	}
+	if len(silences) == 0 {
+		return Silence{}, ErrNotFound
+	}
+	return silences[0], nil
+}
+
+func (p *Postgres) MetricRange(ctx context.Context, q MetricQuery) (MetricSeries, error) {
+	out, err := metricPlan(q, time.Now())
+	if err != nil {
+		return out, err
+	}
+	var rows *sql.Rows
+	if out.Resolution == ResolutionRaw {
+		rows, err = p.db.QueryContext(ctx, `SELECT ts, 1, value, value, value FROM metric_samples
+			WHERE host = $1 AND metric = $2 AND ts >= $3 AND ts < $4 ORDER BY ts`,
+			q.Host, q.Metric, out.From, out.To)
+	} else {
+		rows, err = p.db.QueryContext(ctx, `SELECT ts, count, sum, min, max FROM metric_rollups
+			WHERE host = $1 AND metric = $2 AND ts >= $3 AND ts < $4 ORDER BY ts`,
+			q.Host, q.Metric, minuteOf(out.From), out.To)
+	}
+	if err != nil {
+		return out, fmt.Errorf("postgres: metric range: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			t           time.Time
+			count       int64
+			sum, lo, hi float64
+		)
+		if err := rows.Scan(&t, &count, &sum, &lo, &hi); err != nil {
+			return out, fmt.Errorf("postgres: metric range: %w", err)
+		}
+		out.add(t, count, sum, lo, hi)
+	}
+	if err := rows.Err(); err != nil {
+		return out, fmt.Errorf("postgres: metric range: %w", err)
+	}
+	out.finish()
+	return out, nil
+}
+
+func (p *Postgres) MetricNames(ctx context.Context, host string) ([]MetricName, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT DISTINCT host, metric FROM metric_rollups
+		WHERE $1 = '' OR host = $1 ORDER BY host, metric`, host)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: metric names: %w", err)
+	}
+	defer rows.Close()
+	out := []MetricName{}
+	for rows.Next() {
+		var n MetricName
+		if err := rows.Scan(&n.Host, &n.Metric); err != nil {
+			return nil, fmt.Errorf("postgres: metric names: %w", err)
+		}
+		out = append(out, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: metric names: %w", err)
+	}
+	return out, nil
+}
+
+func (p *Postgres) PruneMetrics(ctx context.Context, now time.Time) error {
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM metric_samples WHERE ts < $1`, now.Add(-RawMetricRetention)); err != nil {
+		return fmt.Errorf("postgres: prune metrics: %w", err)
+	}
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM metric_rollups WHERE ts < $1`, now.Add(-RollupRetention)); err != nil {
+		return fmt.Errorf("postgres: prune metric rollups: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	// Planner estimates are good enough for health output and stay cheap
+	// on large tables.
+	err := p.db.QueryRowContext(ctx, `SELECT
+		(SELECT GREATEST(reltuples, 0)::BIGINT FROM pg_class WHERE relname = 'logs'),
+		(SELECT GREATEST(reltuples, 0)::BIGINT FROM pg_class WHERE relname = 'alerts')`,
+	).Scan(&s.Logs, &s.Alerts)
+	if err != nil {
+		return Stats{}, fmt.Errorf("postgres: stats: %w", err)
+	}
+	return s, nil
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+func scanDocs[T any](rows *sql.Rows) ([]T, error) {
+	defer rows.Close()
+
+	var out []T
+	for rows.Next() {
+		var (
+			doc []byte
+			v   T
+		)
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(doc, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func scanAlerts(rows *sql.Rows) ([]Alert, error) {
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		var (
+			id                int64
+			doc               []byte
+			status, assignee  string
+			updated, lastSeen time.Time
+			count             int
+			score             float64
+			a                 Alert
+		)
+		if err := rows.Scan(&id, &doc, &status, &assignee, &updated, &count, &lastSeen, &score); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(doc, &a); err != nil {
+			return nil, fmt.Errorf("decode alert %d: %w", id, err)
+		}
+		a.ID = uint(id)
+		a.Status, a.Assignee, a.UpdatedAt = status, assignee, updated
+		a.Count, a.LastSeen, a.Score = count, lastSeen, score
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+func scanSilences(rows *sql.Rows) ([]Silence, error) {
+	defer rows.Close()
+
+	var silences []Silence
+	for rows.Next() {
+		var (
+			id       int64
+			matchers []byte
+			s        Silence
+		)
+		if err := rows.Scan(&id, &matchers, &s.Comment, &s.CreatedBy, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(matchers, &s.Matchers); err != nil {
+			return nil, fmt.Errorf("decode silence %d: %w", id, err)
+		}
+		s.ID = uint(id)
+		silences = append(silences, s)
+	}
+	return silences, rows.Err()
+}
+
+// inet converts an address to a value for an INET column; anything that
+// is not an IP is stored as NULL.
+func inet(ip string) any {
+	if net.ParseIP(ip) == nil {
+		return nil
+	}
+	return ip
+}
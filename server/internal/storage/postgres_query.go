@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/query"
+)
+
+// querySQL translates a search query to a WHERE condition. column
+// returns the SQL text expression of a field, NULL where the document
+// lacks it; arg adds a query argument and returns its placeholder. Every
+// term is COALESCEd to false so NOT of a missing field matches, as in
+// query.Match. pg_input_is_valid needs PostgreSQL 16.
+func querySQL(e query.Expr, column func(field string) string, arg func(any) string, inet map[string]string) string {
+	switch e := e.(type) {
+	case *query.And:
+		return "(" + querySQL(e.X, column, arg, inet) + " AND " + querySQL(e.Y, column, arg, inet) + ")"
+	case *query.Or:
+		return "(" + querySQL(e.X, column, arg, inet) + " OR " + querySQL(e.Y, column, arg, inet) + ")"
+	case *query.Not:
+		return "NOT " + querySQL(e.X, column, arg, inet)
+	}
+
+	t := e.(*query.Term)
+	if c, ok := inet[t.Field]; ok && t.Op == query.CIDR {
+		return "COALESCE(" + c + " <<= " + arg(t.Value) + "::INET, false)"
+	}
+	col := column(t.Field)
+	number := func(cmp string) string {
+		return "CASE WHEN pg_input_is_valid(" + col + ", 'float8') THEN (" + col + ")::FLOAT8 " + cmp + " " + arg(t.Num) + " ELSE false END"
+	}
+	var cond string
+	switch t.Op {
+	case query.Eq:
+		cond = "lower(" + col + ") = lower(" + arg(t.Value) + "::TEXT)"
+		if t.IsNum {
+			cond = "CASE WHEN pg_input_is_valid(" + col + ", 'float8') THEN (" + col + ")::FLOAT8 = " + arg(t.Num) + " ELSE " + cond + " END"
+		}
+	case query.Glob:
+		cond = col + " ILIKE " + arg(t.Like()) + "::TEXT"
+	case query.CIDR:
+		cond = "CASE WHEN pg_input_is_valid(" + col + ", 'inet') THEN (" + col + ")::INET <<= " + arg(t.Value) + "::INET ELSE false END"
+	case query.Lt:
+		cond = number("<")
+	case query.Lte:
+		cond = number("<=")
+	case query.Gt:
+		cond = number(">")
+	case query.Gte:
+		cond = number(">=")
+	}
+	return "COALESCE(" + cond + ", false)"
+}
+
+// logColumn maps LogSchema fields to the logs table.
+func logColumn(doc string, arg func(any) string) func(string) string {
+	return func(field string) string {
+		switch field {
+		case "host", "source", "level", "event_type":
+			if doc == "doc" {
+				return field
+			}
+		case "user":
+			if doc == "doc" {
+				return "username"
+			}
+		}
+		if key, ok := strings.CutPrefix(field, parser.FieldPrefix); ok {
+			return "(" + doc + "->'fields'->>" + arg(key) + "::TEXT)"
+		}
+		if key, ok := strings.CutPrefix(field, parser.MetricPrefix); ok {
+			return "(" + doc + "->'metrics'->>" + arg(key) + "::TEXT)"
+		}
+		if id, param, ok := parser.SDParam(field); ok {
+			return "(" + doc + "->'sd'->" + arg(id) + "::TEXT->>" + arg(param) + "::TEXT)"
+		}
+		return "(" + doc + "->>'" + field + "')"
+	}
+}
+
+// alertColumn maps AlertSchema fields to the alerts table.
+func alertColumn(arg func(any) string) func(string) string {
+	logField := logColumn("doc->'log'", arg)
+	return func(field string) string {
+		switch field {
+		case "id", "score", "count":
+			return field + "::TEXT"
+		case "rule", "severity", "status", "assignee", "dedup_key", "host":
+			return field
+		case "message":
+			return "(doc->>'message')"
+		case "mitre":
+			return "array_to_string(ARRAY(SELECT jsonb_array_elements_text(doc->'mitre')), ',')"
+		}
+		return logField(field)
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/siem/internal/query"
+)
+
+// translate returns the WHERE condition of a log or alert query and its
+// arguments.
+func translate(t *testing.T, q string, alerts bool) (string, []any) {
+	t.Helper()
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if alerts {
+		parsed, err := query.Parse(q, AlertSchema)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", q, err)
+		}
+		return querySQL(parsed.Root, alertColumn(arg), arg, nil), args
+	}
+	parsed, err := query.Parse(q, LogSchema)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", q, err)
+	}
+	return querySQL(parsed.Root, logColumn("doc", arg), arg, map[string]string{"src_ip": "src_ip"}), args
+}
+
+func TestQuerySQL(t *testing.T) {
+	tests := []struct {
+		query  string
+		alerts bool
+		sql    string
+		args   []any
+	}{
+		{
+			query: "user:root AND NOT host:web*",
+			sql:   "(COALESCE(lower(username) = lower($1::TEXT), false) AND NOT COALESCE(host ILIKE $2::TEXT, false))",
+			args:  []any{"root", "web%"},
+		},
+		{
+			query: "src_ip:10.0.0.0/8 OR fields.path:/etc/50%_x",
+			sql:   "(COALESCE(src_ip <<= $1::INET, false) OR COALESCE(lower((doc->'fields'->>$2::TEXT)) = lower($3::TEXT), false))",
+			args:  []any{"10.0.0.0/8", "path", "/etc/50%_x"},
+		},
+		{
+			query: "metrics.cpu:>90",
+			sql:   "COALESCE(CASE WHEN pg_input_is_valid((doc->'metrics'->>$1::TEXT), 'float8') THEN ((doc->'metrics'->>$1::TEXT))::FLOAT8 > $2 ELSE false END, false)",
+			args:  []any{"cpu", 90.0},
+		},
+		{
+			query: `pid:42 "failed password"`,
+			sql:   "(COALESCE(CASE WHEN pg_input_is_valid((doc->>'pid'), 'float8') THEN ((doc->>'pid'))::FLOAT8 = $2 ELSE lower((doc->>'pid')) = lower($1::TEXT) END, false) AND COALESCE((doc->>'msg') ILIKE $3::TEXT, false))",
+			args:  []any{"42", 42.0, "%failed password%"},
+		},
+		{
+			query:  "rule:SSH_BRUTEFORCE count:>=10 src_ip:10.0.0.0/8",
+			alerts: true,
+			sql:    "((COALESCE(lower(rule) = lower($1::TEXT), false) AND COALESCE(CASE WHEN pg_input_is_valid(count::TEXT, 'float8') THEN (count::TEXT)::FLOAT8 >= $2 ELSE false END, false)) AND COALESCE(CASE WHEN pg_input_is_valid((doc->'log'->>'src_ip'), 'inet') THEN ((doc->'log'->>'src_ip'))::INET <<= $3::INET ELSE false END, false))",
+			args:   []any{"SSH_BRUTEFORCE", 10.0, "10.0.0.0/8"},
+		},
+	}
+	for _, tt := range tests {
+		sql, args := translate(t, tt.query, tt.alerts)
+		if sql != tt.sql {
+			t.Errorf("%q:\n got %s\nwant %s", tt.query, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%q: args %#v, want %#v", tt.query, args, tt.args)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/query"
+)
+
+// LogSchema and AlertSchema are the schemas of search queries over logs
+// and alerts. Alert queries may also use the fields of the alert's log.
+var (
+	LogSchema   = query.Schema{Text: "msg", Known: parser.KnownField}
+	AlertSchema = query.Schema{Text: "message", Known: KnownAlertField}
+)
+
+var alertFields = []string{
+	"id", "rule", "severity", "score", "message", "mitre", "status",
+	"assignee", "count", "dedup_key",
+}
+
+// KnownAlertField reports whether name can be passed to Alert.Field.
+func KnownAlertField(name string) bool {
+	return slices.Contains(alertFields, name) || parser.KnownField(name)
+}
+
+// Field returns a field of the alert by its JSON name, or a field of its
+// log. Mitre techniques are joined with commas.
+func (a Alert) Field(name string) (string, bool) {
+	switch name {
+	case "id":
+		return strconv.FormatUint(uint64(a.ID), 10), true
+	case "rule":
+		return a.Rule, true
+	case "severity":
+		return a.Severity, true
+	case "score":
+		return strconv.FormatFloat(a.Score, 'g', -1, 64), true
+	case "message":
+		return a.Message, true
+	case "mitre":
+		return strings.Join(a.Mitre, ","), true
+	case "status":
+		return a.Status, true
+	case "assignee":
+		return a.Assignee, true
+	case "count":
+		return strconv.Itoa(a.Count), true
+	case "dedup_key":
+		return a.DedupKey, true
+	}
+	return a.Log.Field(name)
+}
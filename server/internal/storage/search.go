@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/query"
+)
+
+// ErrInvalidCursor is returned for cursors not issued by SearchLogs.
+var ErrInvalidCursor = errors.New("storage: invalid cursor")
+
+// LogQuery selects logs for SearchLogs. Empty fields match all logs.
+type LogQuery struct {
+	Host      string
+	Source    string
+	Level     string
+	EventType string
+	SrcIP     string // an address or a CIDR range
+	User      string
+	Text      string       // case-insensitive substring of the message
+	Query     *query.Query // parsed with LogSchema
+	From, To  time.Time
+	Asc       bool // oldest first; the default is newest first
+	Limit     int  // defaults to 100, at most 1000
+	Cursor    string
+}
+
+// LogPage is one page of search results. Next is empty on the last page.
+type LogPage struct {
+	Logs []parser.NormalizedLog `json:"logs"`
+	Next string                 `json:"next_cursor,omitempty"`
+}
+
+// cursor is the sort position of the last log of a page. Logs are
+// ordered by timestamp, then by storage ID.
+type cursor struct {
+	TS time.Time `json:"t"`
+	ID uint64    `json:"i"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.ID == 0 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// after reports whether a log at (ts, id) comes after c in the order of
+// q.
+func (c cursor) after(ts time.Time, id uint64, asc bool) bool {
+	switch {
+	case !ts.Equal(c.TS):
+		return ts.After(c.TS) == asc
+	case id == c.ID:
+		return false
+	}
+	return (id > c.ID) == asc
+}
+
+func (q LogQuery) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return 100
+	case q.Limit > 1000:
+		return 1000
+	}
+	return q.Limit
+}
+
+// srcNet parses SrcIP as a CIDR range; nil means an exact match.
+func (q LogQuery) srcNet() *net.IPNet {
+	_, n, err := net.ParseCIDR(q.SrcIP)
+	if err != nil {
+		return nil
+	}
+	return n
+}
+
+// matcher returns the filter of q for backends that filter in Go.
+func (q LogQuery) matcher() func(l *parser.NormalizedLog) bool {
+	text := strings.ToLower(q.Text)
+	srcNet := q.srcNet()
+	return func(l *parser.NormalizedLog) bool {
+		switch {
+		case q.Host != "" && l.Host != q.Host,
+			q.Source != "" && l.Source != q.Source,
+			q.Level != "" && l.Level != q.Level,
+			q.EventType != "" && l.EventType != q.EventType,
+			q.User != "" && l.User != q.User,
+			!q.From.IsZero() && l.Timestamp.Before(q.From),
+			!q.To.IsZero() && !l.Timestamp.Before(q.To),
+			text != "" && !strings.Contains(strings.ToLower(l.Message), text),
+			q.Query != nil && !q.Query.Match(l):
+			return false
+		}
+		if q.SrcIP == "" {
+			return true
+		}
+		if srcNet != nil {
+			ip := net.ParseIP(l.SrcIP)
+			return ip != nil && srcNet.Contains(ip)
+		}
+		return l.SrcIP == q.SrcIP
+	}
+}
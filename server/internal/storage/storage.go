@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/siem/internal/parser"
+)
+
+// Storage persists normalized logs and the alerts raised on them.
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	SaveLogs(ctx context.Context, logs []parser.NormalizedLog) error
+	// SaveAlerts and SaveBatch set the ID and initial status of the
+	// stored alerts in place. An alert deduplicated into an open alert is
+	// replaced by the updated open alert.
+	SaveAlerts(ctx context.Context, alerts []Alert) error
+	// BatchSeen reports whether the agent batch has been stored already.
+	BatchSeen(ctx context.Context, agentID string, seq uint64) (bool, error)
+	// SaveBatch atomically stores the logs and alerts of an agent batch
+	// and records it as seen. It stores nothing and reports duplicate
+	// when the batch was stored before.
+	SaveBatch(ctx context.Context, b Batch) (duplicate bool, err error)
+	// RecentLogs and RecentAlerts return up to limit newest entries,
+	// oldest first.
+	RecentLogs(ctx context.Context, limit int) ([]parser.NormalizedLog, error)
+	RecentAlerts(ctx context.Context, limit int) ([]Alert, error)
+	// SearchLogs returns one page of logs matching q, ordered by
+	// timestamp. Page.Next continues the search when set as q.Cursor.
+	SearchLogs(ctx context.Context, q LogQuery) (LogPage, error)
+	// LogHistogram counts the logs matching q per interval since the
+	// Unix epoch, oldest first. Empty buckets from q.From (or the first
+	// log) to q.To (or the last log) are included.
+	LogHistogram(ctx context.Context, q LogQuery, interval time.Duration) ([]Bucket, error)
+	// TopTerms returns the size most frequent non-empty values of a log
+	// field among the logs matching q.
+	TopTerms(ctx context.Context, q LogQuery, field string, size int) ([]TermCount, error)
+	// Cardinality counts the distinct non-empty values of field among the
+	// logs matching q. With by set it returns the size groups of the by
+	// field with most values, otherwise a single count with an empty Term.
+	Cardinality(ctx context.Context, q LogQuery, field, by string, size int) ([]TermCount, error)
+	// ListAlerts returns matching alerts newest first, without history.
+	ListAlerts(ctx context.Context, f AlertFilter) ([]Alert, error)
+	// Alert returns one alert with its history, or ErrNotFound.
+	Alert(ctx context.Context, id uint) (Alert, error)
+	// UpdateAlert applies u, records it in the history and returns the
+	// updated alert.
+	UpdateAlert(ctx context.Context, id uint, u AlertUpdate) (Alert, error)
+	// CreateSilence stores s and returns it with ID and CreatedAt set.
+	CreateSilence(ctx context.Context, s Silence) (Silence, error)
+	// ListSilences returns silences newest first, expired ones only when
+	// asked.
+	ListSilences(ctx context.Context, includeExpired bool) ([]Silence, error)
+	// ExpireSilence ends a silence now, or returns ErrNotFound.
+	ExpireSilence(ctx context.Context, id uint) (Silence, error)
+	// MetricRange returns one metric of one host, averaged per step. The
+	// metrics events stored by SaveLogs and SaveBatch are its samples.
+	MetricRange(ctx context.Context, q MetricQuery) (MetricSeries, error)
+	// MetricNames returns the metrics reported by host, or by all hosts
+	// when host is empty, ordered by host and metric.
+	MetricNames(ctx context.Context, host string) ([]MetricName, error)
+	// PruneMetrics drops the raw samples older than RawMetricRetention and
+	// the rollups older than RollupRetention.
+	PruneMetrics(ctx context.Context, now time.Time) error
+	Stats(ctx context.Context) (Stats, error)
+	Close() error
+}
+
+type Stats struct {
+	Logs   int64 `json:"logs"`
+	Alerts int64 `json:"alerts"`
+}
+
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+)
+
+type Config struct {
+	// Backend is "memory" or "postgres". Empty selects postgres when
+	// DatabaseURL is set and memory otherwise.
+	Backend     string
+	DatabaseURL string
+	MaxLogs     int
+	MaxAlerts   int
+}
+
+func Open(ctx context.Context, cfg Config) (Storage, error) {
+	backend := cfg.Backend
+	if backend == "" {
+		backend = BackendMemory
+		if cfg.DatabaseURL != "" {
+			backend = BackendPostgres
+		}
+	}
+
+	switch backend {
+	case BackendMemory:
+		return NewMemory(cfg.MaxLogs, cfg.MaxAlerts), nil
+	case BackendPostgres:
+		if cfg.DatabaseURL == "" {
+			return nil, fmt.Errorf("storage: postgres backend requires DATABASE_URL")
+		}
+		return NewPostgres(ctx, cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", backend)
+	}
+}
@@ -0,0 +1,144 @@
+// Package stream fans new logs and alerts out to live dashboard clients.
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/siem/internal/parser"
+	"github.com/siem/internal/query"
+	"github.com/siem/internal/storage"
+)
+
+const defaultBuffer = 256
+
+// Event is one message to a subscriber.
+type Event struct {
+	Type    string                `json:"type"` // log, alert or dropped
+	Log     *parser.NormalizedLog `json:"log,omitempty"`
+	Alert   *storage.Alert        `json:"alert,omitempty"`
+	Dropped int64                 `json:"dropped,omitempty"` // events lost since the last one sent
+}
+
+// Filter selects the events of a subscription. A nil query matches all
+// logs or alerts of an enabled stream.
+type Filter struct {
+	Logs, Alerts bool
+	LogQuery     *query.Query // parsed with storage.LogSchema
+	AlertQuery   *query.Query // parsed with storage.AlertSchema
+}
+
+func (f *Filter) log(l *parser.NormalizedLog) bool {
+	return f.Logs && (f.LogQuery == nil || f.LogQuery.Match(l))
+}
+
+func (f *Filter) alert(a *storage.Alert) bool {
+	return f.Alerts && (f.AlertQuery == nil || f.AlertQuery.Match(a))
+}
+
+// Hub delivers published events to the subscriptions whose filter they
+// match. Publish never blocks: each subscription has a bounded buffer,
+// and events for a subscriber that doesn't keep up are dropped and
+// counted instead.
+type Hub struct {
+	buffer  int
+	dropped atomic.Int64
+
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewHub returns a hub buffering up to buffer events per subscription
+// (default 256).
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+	return &Hub{buffer: buffer, subs: make(map[*Subscription]struct{})}
+}
+
+type Subscription struct {
+	hub     *Hub
+	events  chan Event
+	filter  atomic.Pointer[Filter]
+	dropped atomic.Int64
+}
+
+// Subscribe registers a subscription; it must be closed with
+// Unsubscribe.
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	s := &Subscription{hub: h, events: make(chan Event, h.buffer)}
+	s.filter.Store(&f)
+
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// Publish offers new logs and alerts to all subscriptions.
+func (h *Hub) Publish(logs []parser.NormalizedLog, alerts []storage.Alert) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.subs) == 0 {
+		return
+	}
+
+	for i := range logs {
+		l := logs[i]
+		h.send(func(f *Filter) bool { return f.log(&l) }, Event{Type: "log", Log: &l})
+	}
+	for i := range alerts {
+		a := alerts[i]
+		h.send(func(f *Filter) bool { return f.alert(&a) }, Event{Type: "alert", Alert: &a})
+	}
+}
+
+func (h *Hub) send(match func(f *Filter) bool, ev Event) {
+	for s := range h.subs {
+		if !match(s.filter.Load()) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			s.dropped.Add(1)
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// Clients returns the number of subscriptions.
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Dropped returns how many events were dropped for slow subscribers
+// since start.
+func (h *Hub) Dropped() int64 {
+	return h.dropped.Load()
+}
+
+// Events returns the buffered events of s.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// SetFilter replaces the filter of s for events published afterwards.
+func (s *Subscription) SetFilter(f Filter) {
+	s.filter.Store(&f)
+}
+
+// TakeDropped returns the number of events dropped since the previous
+// call. Writers send it as a "dropped" event before the next event.
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
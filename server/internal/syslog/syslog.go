@@ -0,0 +1,357 @@
+// Package syslog receives syslog messages over UDP (RFC 5426), TCP with
+// octet-counting or newline framing (RFC 6587) and TLS (RFC 5425).
+//
+// Messages are handed on in batches per sender, in the order they
+// arrived. While the handler is busy, receiving stalls: TCP and TLS
+// senders are pushed back on and UDP datagrams queue in the kernel until
+// it drops them.
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxMessage is the size limit of a message, as for UDP datagrams.
+const MaxMessage = 64 << 10
+
+const (
+	defaultBatch    = 500
+	defaultFlush    = time.Second
+	defaultIdle     = 5 * time.Minute
+	defaultMaxConns = 1000
+	// handshakeTimeout bounds the TLS handshake of a connection.
+	handshakeTimeout = 10 * time.Second
+)
+
+type Message struct {
+	Transport string // "udp", "tcp" or "tls"
+	Host      string // sender address
+	Line      string
+	Received  time.Time
+}
+
+type Config struct {
+	// Listen addresses, e.g. ":514"; empty ones are off.
+	UDP, TCP, TLS string
+	TLSConfig     *tls.Config // required with TLS
+	// Batch caps the messages of a batch; defaults to 500. Batches are
+	// handed on at least every FlushInterval, which defaults to a second.
+	Batch         int
+	FlushInterval time.Duration
+	// A TCP or TLS connection is closed when a message takes longer than
+	// IdleTimeout to arrive, 5 minutes by default. At most MaxConns
+	// connections are served at once, 1000 by default; more are refused.
+	IdleTimeout time.Duration
+	MaxConns    int
+	// Handle gets the batches, each of one sender, from one goroutine.
+	Handle func(batch []Message)
+}
+
+type Server struct {
+	cfg      Config
+	msgs     chan Message
+	received atomic.Int64
+
+	mu      sync.Mutex
+	closed  bool
+	closers map[io.Closer]struct{} // listeners and connections
+	conns   int                    // TCP and TLS connections served
+
+	readers   sync.WaitGroup
+	collected chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen opens the listeners of cfg and starts receiving.
+func Listen(cfg Config) (*Server, error) {
+	if cfg.Batch <= 0 {
+		cfg.Batch = defaultBatch
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlush
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultIdle
+	}
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = defaultMaxConns
+	}
+	if cfg.TLS != "" && cfg.TLSConfig == nil {
+		return nil, errors.New("syslog: tls: no certificate")
+	}
+	s := &Server{
+		cfg:       cfg,
+		msgs:      make(chan Message, cfg.Batch),
+		closers:   map[io.Closer]struct{}{},
+		collected: make(chan struct{}),
+	}
+	go s.collect()
+
+	if cfg.UDP != "" {
+		pc, err := net.ListenPacket("udp", cfg.UDP)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("syslog: udp: %w", err)
+		}
+		s.track(pc)
+		s.readers.Add(1)
+		go s.serveUDP(pc)
+	}
+	for _, l := range []struct {
+		transport, addr string
+		listen          func() (net.Listener, error)
+	}{
+		{"tcp", cfg.TCP, func() (net.Listener, error) { return net.Listen("tcp", cfg.TCP) }},
+		{"tls", cfg.TLS, func() (net.Listener, error) { return tls.Listen("tcp", cfg.TLS, cfg.TLSConfig) }},
+	} {
+		if l.addr == "" {
+			continue
+		}
+		ln, err := l.listen()
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("syslog: %s: %w", l.transport, err)
+		}
+		s.track(ln)
+		s.readers.Add(1)
+		go s.serveStream(l.transport, ln)
+	}
+	return s, nil
+}
+
+// Received returns the number of messages received.
+func (s *Server) Received() int64 { return s.received.Load() }
+
+// Close stops the listeners and connections and hands on the messages
+// received so far.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		for c := range s.closers {
+			c.Close()
+		}
+		s.mu.Unlock()
+
+		s.readers.Wait()
+		close(s.msgs)
+		<-s.collected
+	})
+}
+
+// track registers c to be closed by Close; false if that already ran.
+func (s *Server) track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closers[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.mu.Lock()
+	delete(s.closers, c)
+	s.mu.Unlock()
+}
+
+// trackConn is track for a connection, which also counts against
+// MaxConns; full is true when there are that many.
+func (s *Server) trackConn(c net.Conn) (ok, full bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, false
+	}
+	if s.conns >= s.cfg.MaxConns {
+		return false, true
+	}
+	s.conns++
+	s.closers[c] = struct{}{}
+	return true, false
+}
+
+func (s *Server) untrackConn(c net.Conn) {
+	s.mu.Lock()
+	s.conns--
+	delete(s.closers, c)
+	s.mu.Unlock()
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) {
+	defer s.readers.Done()
+	buf := make([]byte, MaxMessage)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("⚠️ Syslog udp: %v", err)
+			continue
+		}
+		s.receive("udp", addr, string(buf[:n]))
+	}
+}
+
+func (s *Server) serveStream(transport string, ln net.Listener) {
+	defer s.readers.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("⚠️ Syslog %s: %v", transport, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		ok, full := s.trackConn(conn)
+		if full {
+			log.Printf("⚠️ Syslog %s: %d connections, refusing %s", transport, s.cfg.MaxConns, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if !ok {
+			conn.Close()
+			return
+		}
+		s.readers.Add(1)
+		go s.serveConn(transport, conn)
+	}
+}
+
+func (s *Server) serveConn(transport string, conn net.Conn) {
+	defer s.readers.Done()
+	defer s.untrackConn(conn)
+	defer conn.Close()
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tc.Handshake(); err != nil {
+			log.Printf("⚠️ Syslog %s %s: %v", transport, conn.RemoteAddr(), err)
+			return
+		}
+		tc.SetDeadline(time.Time{})
+	}
+
+	r := bufio.NewReaderSize(conn, MaxMessage)
+	for {
+		// Each message must arrive in full within the idle timeout, so
+		// neither silent nor trickling senders hold on to a connection.
+		conn.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+		line, err := readFrame(r)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("⚠️ Syslog %s %s: no message within %v, closing", transport, conn.RemoteAddr(), s.cfg.IdleTimeout)
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("⚠️ Syslog %s %s: %v", transport, conn.RemoteAddr(), err)
+			}
+			return
+		}
+		s.receive(transport, conn.RemoteAddr(), line)
+	}
+}
+
+// readFrame reads an octet-counted ("LEN MSG") or newline-terminated
+// message. Senders may mix both.
+func readFrame(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	var prefix []byte
+	if b[0] >= '1' && b[0] <= '9' {
+		n := 0
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if c == ' ' {
+				buf := make([]byte, n)
+				_, err := io.ReadFull(r, buf)
+				return string(buf), err
+			}
+			prefix = append(prefix, c)
+			if c == '\n' {
+				return string(prefix), nil
+			}
+			if c < '0' || c > '9' {
+				// A newline-framed message without PRI that starts
+				// with a digit.
+				break
+			}
+			if n = n*10 + int(c-'0'); n > MaxMessage {
+				return "", fmt.Errorf("frame of more than %d bytes", MaxMessage)
+			}
+		}
+	}
+
+	line, err := r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		return "", fmt.Errorf("line of more than %d bytes", MaxMessage)
+	case errors.Is(err, io.EOF) && len(prefix)+len(line) > 0:
+		err = nil
+	}
+	return string(prefix) + string(line), err
+}
+
+func (s *Server) receive(transport string, addr net.Addr, line string) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if line == "" {
+		return
+	}
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	s.received.Add(1)
+	s.msgs <- Message{Transport: transport, Host: host, Line: line, Received: time.Now().UTC()}
+}
+
+// collect batches the messages per sender.
+func (s *Server) collect() {
+	defer close(s.collected)
+
+	pending := map[string][]Message{}
+	flush := func() {
+		for host, batch := range pending {
+			s.cfg.Handle(batch)
+			delete(pending, host)
+		}
+	}
+	tick := time.NewTicker(s.cfg.FlushInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case m, ok := <-s.msgs:
+			if !ok {
+				flush()
+				return
+			}
+			batch := append(pending[m.Host], m)
+			if len(batch) < s.cfg.Batch {
+				pending[m.Host] = batch
+				continue
+			}
+			s.cfg.Handle(batch)
+			delete(pending, m.Host)
+		case <-tick.C:
+			flush()
+		}
+	}
+}